@@ -0,0 +1,293 @@
+// Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
+
+package objregexp
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/gilramir/objregexp/syntax"
+)
+
+// A Pattern is a regex which is built by calling functions, like
+// Seq, Alt and ClassRef, instead of being written as a string. Since
+// names are given as Go strings, they can have any characters in them,
+// without having to worry about the regex syntax.
+//
+//	p := objregexp.Seq(
+//		objregexp.ClassRef("vowel"),
+//		objregexp.Star(objregexp.And(
+//			objregexp.ClassRef("lower"),
+//			objregexp.Not(objregexp.IdentityRef("x")))))
+//	regex, err := compiler.Build(p)
+//
+// A Pattern can be used more than once, even inside the same Pattern,
+// and each use of a Group in it is a new capture group. A NamedGroup
+// can't be, though, as the names of the capture groups must be unique.
+type Pattern interface {
+	// Produce the syntax tree for the pattern
+	build(b *builderT) *syntax.Regexp
+}
+
+// The state needed to convert a Pattern into a syntax tree
+type builderT struct {
+	// Returns the kind of the name in the compiler, or 0 if the
+	// compiler doesn't know about it. If the name is ambiguous, the
+	// prefixes of the imports which have it are returned.
+	resolve func(name string) (ccType, []string, error)
+
+	// The number of capture groups so far
+	numGroups int
+
+	// The names of the capture groups so far
+	groupNames map[string]bool
+
+	// The first problem found in the Pattern
+	err error
+}
+
+func (s *builderT) errorf(f string, args ...any) {
+	if s.err == nil {
+		s.err = fmt.Errorf(f, args...)
+	}
+}
+
+// A pattern which isn't a class test
+type patternT struct {
+	op   syntax.Op
+	sub  []Pattern
+	name string
+}
+
+func (s *patternT) build(b *builderT) *syntax.Regexp {
+	re := &syntax.Regexp{Op: s.op}
+	if s.op == syntax.OpCapture {
+		// Groups are numbered in the order that they start,
+		// just as they are in a regex string
+		b.numGroups++
+		re.Cap = b.numGroups
+		if s.name != "" {
+			if b.groupNames[s.name] {
+				b.errorf("The capture group name '%s' is used more than once", s.name)
+			}
+			b.groupNames[s.name] = true
+			re.Name = s.name
+		}
+	}
+	re.Sub = make([]*syntax.Regexp, len(s.sub))
+	for i, p := range s.sub {
+		re.Sub[i] = p.build(b)
+	}
+	return re
+}
+
+// Any number of patterns, one after the other
+func Seq(patterns ...Pattern) Pattern {
+	if len(patterns) == 1 {
+		return patterns[0]
+	}
+	return &patternT{op: syntax.OpConcat, sub: patterns}
+}
+
+// A choice between the patterns. The ones that come first
+// are preferred. At least one pattern must be given.
+func Alt(patterns ...Pattern) Pattern {
+	if len(patterns) == 1 {
+		return patterns[0]
+	}
+	return &patternT{op: syntax.OpAlternate, sub: patterns}
+}
+
+// 0 or more of the pattern, like "*"
+func Star(p Pattern) Pattern {
+	return &patternT{op: syntax.OpStar, sub: []Pattern{p}}
+}
+
+// 1 or more of the pattern, like "+"
+func Plus(p Pattern) Pattern {
+	return &patternT{op: syntax.OpPlus, sub: []Pattern{p}}
+}
+
+// 0 or 1 of the pattern, like "?"
+func Opt(p Pattern) Pattern {
+	return &patternT{op: syntax.OpQuest, sub: []Pattern{p}}
+}
+
+// A capture group, like "(...)"
+func Group(p Pattern) Pattern {
+	return &patternT{op: syntax.OpCapture, sub: []Pattern{p}}
+}
+
+// A named capture group, like "(?P<name>...)". The name can't have
+// spaces or non-graphic code points in it, or a '>' or ')', which would
+// end the name in "(?P<name>...)" or in a "(?P=name)" backreference.
+func NamedGroup(name string, p Pattern) Pattern {
+	return &patternT{op: syntax.OpCapture, sub: []Pattern{p}, name: name}
+}
+
+// Matches any one object, like "."
+func Any() Pattern {
+	return &patternT{op: syntax.OpAnyObject}
+}
+
+// Matches the beginning of the input, like "^"
+func Begin() Pattern {
+	return &patternT{op: syntax.OpBeginText}
+}
+
+// Matches the end of the input, like "$"
+func End() Pattern {
+	return &patternT{op: syntax.OpEndText}
+}
+
+// A ClassTerm tests a single object against classes and identities.
+// It is a Pattern which matches one object, and it can be combined
+// with other ClassTerms with Not, And and Or, like "[:a: && !:b:]".
+type ClassTerm struct {
+	op   syntax.ClassOp
+	sub  []*ClassTerm
+	name string
+
+	// For syntax.ClassName, whether name is a class or an identity
+	kind ccType
+}
+
+// Tests that an object is in the class, or identity set, with the name
+func ClassRef(name string) *ClassTerm {
+	return &ClassTerm{op: syntax.ClassName, name: name, kind: ccClass}
+}
+
+// Tests that an object is equal to the identity with the name
+func IdentityRef(name string) *ClassTerm {
+	return &ClassTerm{op: syntax.ClassName, name: name, kind: ccIdentity}
+}
+
+// Negates the test, like the "!" in "[!:a:]"
+func Not(t *ClassTerm) *ClassTerm {
+	return &ClassTerm{op: syntax.ClassNot, sub: []*ClassTerm{t}}
+}
+
+// Tests that an object passes all of the tests. At least one
+// test must be given.
+func And(terms ...*ClassTerm) *ClassTerm {
+	return &ClassTerm{op: syntax.ClassAnd, sub: terms}
+}
+
+// Tests that an object passes any of the tests. At least one
+// test must be given.
+func Or(terms ...*ClassTerm) *ClassTerm {
+	return &ClassTerm{op: syntax.ClassOr, sub: terms}
+}
+
+func (s *ClassTerm) build(b *builderT) *syntax.Regexp {
+	// A name, or a negated name, doesn't need a dynamic class
+	switch {
+	case s.op == syntax.ClassName:
+		b.checkName(s)
+		return &syntax.Regexp{Op: syntax.OpClass, Name: s.name}
+	case s.op == syntax.ClassNot && s.sub[0].op == syntax.ClassName:
+		b.checkName(s.sub[0])
+		return &syntax.Regexp{Op: syntax.OpClass, Name: s.sub[0].name, Negate: true}
+	}
+	return &syntax.Regexp{Op: syntax.OpClassExpr, Class: s.buildExpr(b)}
+}
+
+func (s *ClassTerm) buildExpr(b *builderT) *syntax.ClassExpr {
+	expr := &syntax.ClassExpr{Op: s.op, Name: s.name}
+	switch s.op {
+	case syntax.ClassName:
+		b.checkName(s)
+	case syntax.ClassAnd, syntax.ClassOr:
+		if len(s.sub) == 0 {
+			b.errorf("%s needs at least one test", s.op)
+		}
+	}
+	expr.Sub = make([]*syntax.ClassExpr, len(s.sub))
+	for i, t := range s.sub {
+		expr.Sub[i] = t.buildExpr(b)
+	}
+	return expr
+}
+
+// Check that the name is in the compiler, and is the right kind
+func (s *builderT) checkName(t *ClassTerm) {
+	kind, ambiguous, argErr := s.resolve(t.name)
+	switch {
+	case ambiguous != nil:
+		s.errorf("The name '%s' is ambiguous; it is in the imports %s",
+			t.name, strings.Join(ambiguous, ", "))
+	case argErr != nil:
+		factory, arg, _, _ := syntax.SplitArg(t.name)
+		s.errorf("Invalid argument '%s' to the class factory '%s': %v",
+			arg, factory, argErr)
+	case kind == 0:
+		s.errorf("No such class or identity name '%s'", t.name)
+	case kind == ccIdentity && t.kind == ccClass:
+		s.errorf("'%s' is an identity, not a class", t.name)
+	case kind == ccIdentitySet && t.kind == ccIdentity:
+		s.errorf("'%s' is an identity set, not an identity", t.name)
+	case kind == ccClass && t.kind == ccIdentity:
+		s.errorf("'%s' is a class, not an identity", t.name)
+	}
+}
+
+// Check the parts of the tree which the regex syntax would not allow
+func (s *builderT) check(re *syntax.Regexp) {
+	switch re.Op {
+	case syntax.OpAlternate:
+		if len(re.Sub) == 0 {
+			s.errorf("Alt needs at least one pattern")
+		}
+	case syntax.OpCapture:
+		for _, r := range re.Name {
+			if !unicode.IsGraphic(r) || unicode.IsSpace(r) || r == '>' || r == ')' {
+				s.errorf("The group name '%s' can't have spaces, non-graphic code points, '>' or ')'",
+					re.Name)
+				break
+			}
+		}
+	}
+	for _, sub := range re.Sub {
+		// Inside of anything else, it would be written as nothing,
+		// which the regex syntax doesn't allow
+		if sub.Op == syntax.OpConcat && len(sub.Sub) == 0 {
+			s.errorf("An empty Seq can only be the whole Pattern")
+		}
+		s.check(sub)
+	}
+}
+
+// Build a Regexp from a Pattern, instead of compiling it from a string.
+// An error is returned if a name isn't known, or is of the wrong kind,
+// or if the Pattern has something in it which a regex string couldn't,
+// like an empty Seq inside of an Alt.
+func (s *Compiler[T]) Build(p Pattern) (*Regexp[T], error) {
+	if !s.finalized {
+		return nil, fmt.Errorf("%w. Call Finalize().", ErrNotFinalized)
+	}
+
+	factory := newNfaFactory[T](s)
+	b := &builderT{
+		resolve: func(name string) (ccType, []string, error) {
+			ref, ambiguous := factory.names.resolve(name)
+			return ref.kind, ambiguous, ref.err
+		},
+		groupNames: make(map[string]bool),
+	}
+	tree := p.build(b)
+	b.check(tree)
+	if b.err != nil {
+		return nil, b.err
+	}
+	return factory.compileTree(tree)
+}
+
+// Build a Regexp from a Pattern. On error, raises a panic.
+func (s *Compiler[T]) MustBuild(p Pattern) *Regexp[T] {
+	re, err := s.Build(p)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}
@@ -0,0 +1,162 @@
+// Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
+
+package objregexp
+
+import (
+	. "github.com/gilramir/objregexp/internal/check"
+)
+
+func (s *MySuite) TestBuilder01(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(DigitClass)
+	compiler.AddIdentity("lower x", 'x')
+	compiler.Finalize()
+
+	// Like "^ ([:vowel:]+) (?P<rest>[:digit:] | [:lower x:])* $"
+	re, err := compiler.Build(Seq(
+		Begin(),
+		Group(Plus(ClassRef("vowel"))),
+		Star(NamedGroup("rest", Alt(ClassRef("digit"), IdentityRef("lower x")))),
+		End()))
+	c.Assert(err, IsNil)
+
+	m := re.Match([]rune{'A', 'E', '1', 'x', '2'})
+	c.Assert(m.Success, Equals, true)
+	c.Check(m.Range.End, Equals, 5)
+	c.Check(m.Group(1).Start, Equals, 0)
+	c.Check(m.Group(1).End, Equals, 2)
+	c.Check(m.GroupName("rest").Start, Equals, 2)
+	c.Check(m.GroupName("rest").End, Equals, 5)
+
+	m = re.Match([]rune{'A', 'y'})
+	c.Check(m.Success, Equals, false)
+}
+
+func (s *MySuite) TestBuilder02(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(LowerClass)
+	compiler.AddClass(DigitClass)
+	compiler.AddIdentity("e", 'e')
+	compiler.Finalize()
+
+	// Like "[:lower: && !:vowel:] [!:digit:] [:digit: || (:vowel: && !:e:)] . ?"
+	re := compiler.MustBuild(Seq(
+		And(ClassRef("lower"), Not(ClassRef("vowel"))),
+		Not(ClassRef("digit")),
+		Or(ClassRef("digit"), And(ClassRef("vowel"), Not(IdentityRef("e")))),
+		Opt(Any())))
+
+	c.Check(re.FullMatch([]rune{'x', 'A', 'a'}).Success, Equals, true)
+	c.Check(re.FullMatch([]rune{'x', 'A', '1', '?'}).Success, Equals, true)
+	c.Check(re.FullMatch([]rune{'a', 'A', '1'}).Success, Equals, false)
+	c.Check(re.FullMatch([]rune{'x', '2', '1'}).Success, Equals, false)
+	c.Check(re.FullMatch([]rune{'x', 'A', 'e'}).Success, Equals, false)
+}
+
+// A Pattern can be used more than once; each use gets its own group
+func (s *MySuite) TestBuilder03(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	vowels := Group(Plus(ClassRef("vowel")))
+	re := compiler.MustBuild(Seq(vowels, ClassRef("digit"), vowels))
+
+	m := re.FullMatch([]rune{'A', '1', 'E', 'I'})
+	c.Assert(m.Success, Equals, true)
+	c.Check(m.Group(1).End, Equals, 1)
+	c.Check(m.Group(2).Start, Equals, 2)
+	c.Check(m.Group(2).End, Equals, 4)
+
+	// An empty Seq matches without consuming anything
+	re = compiler.MustBuild(Seq())
+	m = re.Match([]rune{})
+	c.Check(m.Success, Equals, true)
+}
+
+func (s *MySuite) TestBuilderErrors01(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddIdentity("x", 'x')
+
+	_, err := compiler.Build(ClassRef("vowel"))
+	c.Check(err, ErrorMatches, "The objregexp.Compiler is not finalized.*")
+
+	compiler.Finalize()
+
+	_, err = compiler.Build(Seq(ClassRef("vowel"), ClassRef("vowl")))
+	c.Check(err, ErrorMatches, "No such class or identity name 'vowl'")
+
+	_, err = compiler.Build(Not(ClassRef("x")))
+	c.Check(err, ErrorMatches, "'x' is an identity, not a class")
+
+	_, err = compiler.Build(And(ClassRef("vowel"), IdentityRef("vowel")))
+	c.Check(err, ErrorMatches, "'vowel' is a class, not an identity")
+
+	_, err = compiler.Build(Or())
+	c.Check(err, ErrorMatches, "Or needs at least one test")
+
+	_, err = compiler.Build(Alt())
+	c.Check(err, ErrorMatches, "Alt needs at least one pattern")
+
+	_, err = compiler.Build(Alt(ClassRef("vowel"), Seq()))
+	c.Check(err, ErrorMatches, "An empty Seq can only be the whole Pattern")
+	_, err = compiler.Build(Star(Seq()))
+	c.Check(err, ErrorMatches, "An empty Seq can only be the whole Pattern")
+
+	_, err = compiler.Build(Seq(NamedGroup("v", Any()), NamedGroup("v", Any())))
+	c.Check(err, ErrorMatches, "The capture group name 'v' is used more than once")
+
+	_, err = compiler.Build(NamedGroup("a b", Any()))
+	c.Check(err, ErrorMatches, "The group name 'a b' can't have spaces.*")
+
+	// These would end the name early, in the String of the regex
+	_, err = compiler.Build(NamedGroup("a>b", Any()))
+	c.Check(err, ErrorMatches, "The group name 'a>b' can't have .*'>' or '\\)'")
+	_, err = compiler.Build(NamedGroup("a)b", Any()))
+	c.Check(err, ErrorMatches, "The group name 'a\\)b' can't have .*")
+
+	// The same NamedGroup can't be used twice
+	named := NamedGroup("v", Any())
+	_, err = compiler.Build(Seq(named, named))
+	c.Check(err, ErrorMatches, "The capture group name 'v' is used more than once")
+}
+
+// The String of a built Regexp is a regex which compiles to the same
+// Regexp
+func (s *MySuite) TestBuilderString01(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(DigitClass)
+	compiler.AddIdentity("lower x", 'x')
+	compiler.Finalize()
+
+	patterns := []Pattern{
+		Seq(),
+		Seq(Begin(), Group(Plus(ClassRef("vowel"))), End()),
+		Alt(Seq(ClassRef("vowel"), ClassRef("digit")), Star(IdentityRef("lower x"))),
+		Star(Alt(ClassRef("vowel"), Seq(Any(), Any()))),
+		Opt(NamedGroup("n", Seq(Not(ClassRef("digit")), Opt(Any())))),
+		Or(ClassRef("digit"), And(ClassRef("vowel"), Not(IdentityRef("lower x")))),
+	}
+	for _, p := range patterns {
+		built := compiler.MustBuild(p)
+		re, err := compiler.Compile(built.String())
+		c.Assert(err, IsNil, Commentf("%q", built.String()))
+		c.Check(re.String(), Equals, built.String())
+
+		input := []rune("ae1x?")
+		for start := 0; start <= len(input); start++ {
+			c.Check(re.MatchAt(input, start), DeepEquals, built.MatchAt(input, start),
+				Commentf("%q", built.String()))
+		}
+	}
+}
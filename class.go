@@ -2,7 +2,220 @@
 
 package objregexp
 
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
 type Class[T comparable] struct {
 	Name    string
 	Matches func(T) bool
 }
+
+// NewTypeClass returns a class which matches the objects whose dynamic
+// type is V, for a Compiler whose T is an interface type, like any. If
+// predicate isn't nil, the object, as a V, must satisfy it, too. V can be
+// an interface type itself, and then the class matches the objects
+// which implement it.
+//
+// A *NameError wrapping ErrNotInterface is returned if T isn't an
+// interface type, and one wrapping ErrImpossibleType if V is a concrete
+// type which doesn't implement T, as no object would ever match.
+func NewTypeClass[V any, T comparable](name string, predicate func(V) bool) (*Class[T], error) {
+	tType := reflect.TypeOf((*T)(nil)).Elem()
+	vType := reflect.TypeOf((*V)(nil)).Elem()
+	if tType.Kind() != reflect.Interface {
+		return nil, &NameError{Name: name, Err: ErrNotInterface,
+			msg: fmt.Sprintf("Can't make the type class '%s'; the object type %s is not an interface type",
+				name, tType)}
+	}
+	if vType.Kind() != reflect.Interface && !vType.Implements(tType) {
+		return nil, &NameError{Name: name, Err: ErrImpossibleType,
+			msg: fmt.Sprintf("Can't make the type class '%s'; %s does not implement %s",
+				name, vType, tType)}
+	}
+	return &Class[T]{
+		Name: name,
+		Matches: func(o T) bool {
+			v, ok := any(o).(V)
+			if !ok {
+				return false
+			}
+			return predicate == nil || predicate(v)
+		},
+	}, nil
+}
+
+// AddTypeClass registers the class that NewTypeClass makes. Panics if
+// it can't be made, or if AddClass would panic. To match on the type
+// alone, pass nil as the predicate, and give V explicitly:
+//
+//	objregexp.AddTypeClass[*ast.CallExpr](compiler, "call", nil)
+func AddTypeClass[V any, T comparable](compiler *Compiler[T], name string, predicate func(V) bool) {
+	class, err := NewTypeClass[V, T](name, predicate)
+	if err != nil {
+		panic(err.Error())
+	}
+	compiler.AddClass(class)
+}
+
+// Like AddTypeClass, but returns a *NameError instead of panicking.
+func TryAddTypeClass[V any, T comparable](compiler *Compiler[T], name string, predicate func(V) bool) error {
+	class, err := NewTypeClass[V, T](name, predicate)
+	if err != nil {
+		return err
+	}
+	return compiler.TryAddClass(class)
+}
+
+// Registers a field accessor, which returns the value of a field of an
+// object, as a string. Then "[:field=value:]" can be used in a regex,
+// without registering a class for each value; it matches the objects
+// whose field has the value. The value is everything after the first
+// '=', as it is written, spaces and all. A class or identity registered
+// with the same "field=value" name comes first.
+//
+// The field is looked up in child Compilers, and, with its prefix, in
+// Compilers which import this one. Panics if the field can't be
+// registered; see TryAddField.
+func (s *Compiler[T]) AddField(field string, accessor func(T) string) {
+	if err := s.TryAddField(field, accessor); err != nil {
+		panic(err.Error())
+	}
+}
+
+// Like AddField, but returns a *NameError instead of panicking, if the
+// Compiler is finalized, if the field is already registered in this
+// Compiler, or if the field isn't a valid name, or has a '=', '(' or
+// ')' in it.
+func (s *Compiler[T]) TryAddField(field string, accessor func(T) string) error {
+	if s.finalized {
+		return &NameError{Name: field, Err: ErrFinalized,
+			msg: fmt.Sprintf("Can't add the field '%s'; the objregexp.Compiler is already finalized",
+				field)}
+	}
+	if _, has := s.fields[field]; has {
+		return &NameError{Name: field, Err: ErrDuplicateName,
+			msg: fmt.Sprintf("A field with name '%s' already exists", field)}
+	}
+	if strings.Contains(field, "=") {
+		return &NameError{Name: field, Err: ErrIllegalName,
+			msg: fmt.Sprintf("The field '%s' can't have '=' in it", field)}
+	}
+	if strings.ContainsAny(field, "()") {
+		return &NameError{Name: field, Err: ErrIllegalName,
+			msg: fmt.Sprintf("The field '%s' can't have '(' or ')' in it", field)}
+	}
+	if err := CheckName(field); err != nil {
+		return err
+	}
+	s.fields[field] = accessor
+	return nil
+}
+
+// Returns the fields which can be used in "[:field=value:]", sorted.
+// Like Names, this includes the fields of the parent Compilers, and
+// the fields of imports, with their prefixes.
+func (s *Compiler[T]) Fields() []string {
+	return prefixedKeys(s, func(c *Compiler[T]) map[string]func(T) string {
+		return c.fields
+	})
+}
+
+// Creates and registers a class which matches the objects whose field,
+// as returned by the accessor, has any of the values. The accessor
+// doesn't have to be registered with AddField.
+func (s *Compiler[T]) MakeFieldClass(name string, accessor func(T) string, values ...string) {
+	s.AddClass(fieldClass(name, accessor, values))
+}
+
+// Like MakeFieldClass, but returns a *NameError instead of panicking.
+func (s *Compiler[T]) TryMakeFieldClass(name string, accessor func(T) string, values ...string) error {
+	return s.TryAddClass(fieldClass(name, accessor, values))
+}
+
+func fieldClass[T comparable](name string, accessor func(T) string, values []string) *Class[T] {
+	if len(values) == 1 {
+		value := values[0]
+		return &Class[T]{
+			Name: name,
+			Matches: func(o T) bool {
+				return accessor(o) == value
+			},
+		}
+	}
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return &Class[T]{
+		Name: name,
+		Matches: func(o T) bool {
+			_, has := set[accessor(o)]
+			return has
+		},
+	}
+}
+
+// Registers a class factory, which makes a class from an argument.
+// Then "[:factory(arg):]" can be used in a regex, without registering a
+// class for each argument. The factory is given the text between the
+// parens, as it is written, and returns the predicate of the class, or
+// an error if the argument can't be used. The error is reported by
+// Compile as a SyntaxError with the ErrInvalidArgument code, at the
+// argument's position.
+//
+//	compiler.AddClassFactory("longer", func(arg string) (func(string) bool, error) {
+//		n, err := strconv.Atoi(arg)
+//		if err != nil {
+//			return nil, err
+//		}
+//		return func(w string) bool { return len(w) > n }, nil
+//	})
+//
+// The factory is called once each time a regex with the class in it
+// is compiled, however many times the class is in it. A class or
+// identity registered with the same "factory(arg)" name comes first.
+// Like fields, factories are looked up in child Compilers, and, with
+// their prefixes, in Compilers which import this one. Panics if the
+// factory can't be registered; see TryAddClassFactory.
+func (s *Compiler[T]) AddClassFactory(name string, factory func(arg string) (func(T) bool, error)) {
+	if err := s.TryAddClassFactory(name, factory); err != nil {
+		panic(err.Error())
+	}
+}
+
+// Like AddClassFactory, but returns a *NameError instead of panicking,
+// if the Compiler is finalized, if the factory is already registered in
+// this Compiler, or if the name isn't a valid name, or has a '(' or ')'
+// in it.
+func (s *Compiler[T]) TryAddClassFactory(name string, factory func(arg string) (func(T) bool, error)) error {
+	if s.finalized {
+		return &NameError{Name: name, Err: ErrFinalized,
+			msg: fmt.Sprintf("Can't add the class factory '%s'; the objregexp.Compiler is already finalized",
+				name)}
+	}
+	if _, has := s.factories[name]; has {
+		return &NameError{Name: name, Err: ErrDuplicateName,
+			msg: fmt.Sprintf("A class factory with name '%s' already exists", name)}
+	}
+	if strings.ContainsAny(name, "()") {
+		return &NameError{Name: name, Err: ErrIllegalName,
+			msg: fmt.Sprintf("The class factory '%s' can't have '(' or ')' in it", name)}
+	}
+	if err := CheckName(name); err != nil {
+		return err
+	}
+	s.factories[name] = factory
+	return nil
+}
+
+// Returns the class factories which can be used in "[:factory(arg):]",
+// sorted. Like Fields, this includes the factories of the parent
+// Compilers, and the factories of imports, with their prefixes.
+func (s *Compiler[T]) ClassFactories() []string {
+	return prefixedKeys(s, func(c *Compiler[T]) map[string]func(string) (func(T) bool, error) {
+		return c.factories
+	})
+}
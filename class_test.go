@@ -0,0 +1,283 @@
+// Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
+
+package objregexp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	. "github.com/gilramir/objregexp/internal/check"
+)
+
+type shapeT interface {
+	sides() int
+}
+
+type squareT struct{ size int }
+type triangleT struct{ size int }
+
+func (s squareT) sides() int   { return 4 }
+func (s triangleT) sides() int { return 3 }
+
+func (s *MySuite) TestTypeClass01(c *C) {
+	compiler := NewCompiler[shapeT]()
+	AddTypeClass[squareT](compiler, "square", nil)
+	AddTypeClass(compiler, "big triangle", func(t triangleT) bool {
+		return t.size > 10
+	})
+	compiler.Finalize()
+
+	regex := compiler.MustCompile("[:square:]+ [:big triangle:]")
+	c.Check(regex.FullMatch([]shapeT{squareT{1}, squareT{2}, triangleT{20}}).Success,
+		Equals, true)
+	c.Check(regex.FullMatch([]shapeT{squareT{1}, triangleT{5}}).Success, Equals, false)
+	c.Check(regex.FullMatch([]shapeT{squareT{1}, nil}).Success, Equals, false)
+
+	// An object of any type
+	anyCompiler := NewCompiler[any]()
+	AddTypeClass[string](anyCompiler, "string", nil)
+	AddTypeClass[shapeT](anyCompiler, "shape", nil)
+	anyCompiler.AddIdentity("zero", 0)
+	anyCompiler.Finalize()
+
+	anyRegex := anyCompiler.MustCompile("[:string:] [:shape:]* [:zero:]")
+	c.Check(anyRegex.FullMatch([]any{"a", squareT{1}, triangleT{1}, 0}).Success, Equals, true)
+	c.Check(anyRegex.FullMatch([]any{"a", 1, 0}).Success, Equals, false)
+}
+
+func (s *MySuite) TestTypeClass02(c *C) {
+	_, err := NewTypeClass[int, rune]("int", nil)
+	c.Check(errors.Is(err, ErrNotInterface), Equals, true)
+	c.Check(err, ErrorMatches,
+		"Can't make the type class 'int'; the object type int32 is not an interface type")
+
+	compiler := NewCompiler[shapeT]()
+	err = TryAddTypeClass[string](compiler, "string", nil)
+	c.Check(errors.Is(err, ErrImpossibleType), Equals, true)
+	c.Check(err, ErrorMatches,
+		"Can't make the type class 'string'; string does not implement objregexp.shapeT")
+
+	c.Check(TryAddTypeClass[squareT](compiler, "square", nil), IsNil)
+	err = TryAddTypeClass[squareT](compiler, "square", nil)
+	c.Check(errors.Is(err, ErrDuplicateName), Equals, true)
+
+	c.Check(func() { AddTypeClass[int](NewCompiler[int](), "int", nil) }, PanicMatches,
+		"Can't make the type class 'int'; the object type int is not an interface type")
+}
+
+type wordT struct {
+	word string
+	pos  string
+}
+
+func words(text string) []wordT {
+	var ws []wordT
+	for _, f := range strings.Fields(text) {
+		w, pos, _ := strings.Cut(f, "/")
+		ws = append(ws, wordT{word: w, pos: pos})
+	}
+	return ws
+}
+
+func (s *MySuite) TestFieldClass01(c *C) {
+	compiler := NewCompiler[wordT]()
+	compiler.AddField("POS", func(w wordT) string { return w.pos })
+	compiler.AddField("word", func(w wordT) string { return w.word })
+	compiler.MakeFieldClass("noun", func(w wordT) string { return w.pos }, "NN", "NNS")
+	compiler.MakeClass("POS=DT", func(w wordT) bool { return w.word == "the" })
+	compiler.Finalize()
+
+	c.Check(compiler.Fields(), DeepEquals, []string{"POS", "word"})
+	kind, ok := compiler.Lookup("POS=VB")
+	c.Check(ok, Equals, true)
+	c.Check(kind, Equals, KindClass)
+	_, ok = compiler.Lookup("lemma=be")
+	c.Check(ok, Equals, false)
+
+	regex := compiler.MustCompile("[:POS=DT:]? [:POS=JJ:]* ([:noun:]) [:POS=VBD: && !:word=was:]")
+	m := regex.FullMatch(words("the/DT big/JJ dogs/NNS ran/VBD"))
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Group(1), Equals, Range{2, 3})
+	c.Check(regex.FullMatch(words("dog/NN was/VBD")).Success, Equals, false)
+
+	// The registered class comes first
+	c.Check(regex.FullMatch(words("a/DT dog/NN ran/VBD")).Success, Equals, false)
+
+	// Values are compared as they are written
+	regex = compiler.MustCompile("[:word=a b:]")
+	c.Check(regex.FullMatch([]wordT{{word: "a b"}}).Success, Equals, true)
+	regex = compiler.MustCompile("[:word=(:] [:word=): || :word=f(x)):]")
+	c.Check(regex.FullMatch([]wordT{{word: "("}, {word: "f(x))"}}).Success, Equals, true)
+	c.Check(regex.FullMatch([]wordT{{word: "("}, {word: "f(x)"}}).Success, Equals, false)
+	c.Check(CheckName("word=f(x"), IsNil)
+
+	_, err := compiler.Compile("[:POS=NN:] [:lemma=be:]")
+	c.Check(err, ErrorMatches, "No such class or identity name 'lemma=be' at pos 12")
+
+	child := compiler.NewChild()
+	child.AddField("first", func(w wordT) string { return w.word[:1] })
+	child.Finalize()
+	importer := NewCompiler[wordT]()
+	importer.Import("en", child)
+	importer.Finalize()
+	c.Check(importer.Fields(), DeepEquals, []string{"en.POS", "en.first", "en.word"})
+
+	regex = importer.MustCompile("[:en.POS=NN:] [:POS=VBD: && :first=r:]")
+	c.Check(regex.FullMatch(words("dog/NN ran/VBD")).Success, Equals, true)
+	c.Check(regex.FullMatch(words("dog/NN sat/VBD")).Success, Equals, false)
+}
+
+func (s *MySuite) TestFieldClass02(c *C) {
+	compiler := NewCompiler[wordT]()
+	pos := func(w wordT) string { return w.pos }
+	c.Check(compiler.TryAddField("POS", pos), IsNil)
+
+	err := compiler.TryAddField("POS", pos)
+	c.Check(errors.Is(err, ErrDuplicateName), Equals, true)
+	c.Check(err, ErrorMatches, "A field with name 'POS' already exists")
+
+	err = compiler.TryAddField("a=b", pos)
+	c.Check(errors.Is(err, ErrIllegalName), Equals, true)
+	c.Check(err, ErrorMatches, "The field 'a=b' can't have '=' in it")
+
+	err = compiler.TryAddField("a:b", pos)
+	c.Check(errors.Is(err, ErrIllegalName), Equals, true)
+
+	c.Check(compiler.TryMakeFieldClass("noun", pos, "NN"), IsNil)
+	err = compiler.TryMakeFieldClass("noun", pos, "NNS")
+	c.Check(errors.Is(err, ErrDuplicateName), Equals, true)
+
+	compiler.Finalize()
+	err = compiler.TryAddField("lemma", pos)
+	c.Check(errors.Is(err, ErrFinalized), Equals, true)
+}
+
+// A class factory which takes a number
+func longerFactory(arg string) (func(string) bool, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("%d is negative", n)
+	}
+	return func(w string) bool { return len(w) > n }, nil
+}
+
+func (s *MySuite) TestClassFactory01(c *C) {
+	compiler := NewCompiler[string]()
+	compiler.AddClassFactory("longer", longerFactory)
+	compiler.AddClassFactory("prefix", func(arg string) (func(string) bool, error) {
+		return func(w string) bool { return strings.HasPrefix(w, arg) }, nil
+	})
+	compiler.MakeClass("longer(0)", func(w string) bool { return w == "registered" })
+	c.Check(compiler.TryAddIdentity("prefix(z)", "z"), IsNil)
+	compiler.Finalize()
+
+	c.Check(compiler.ClassFactories(), DeepEquals, []string{"longer", "prefix"})
+	kind, ok := compiler.Lookup("longer(3)")
+	c.Check(ok, Equals, true)
+	c.Check(kind, Equals, KindClass)
+	_, ok = compiler.Lookup("longer(x)")
+	c.Check(ok, Equals, false)
+
+	regex := compiler.MustCompile("[:longer(3):]+ [:prefix(un): && !:longer(5):]")
+	c.Check(regex.FullMatch([]string{"long", "words", "undo"}).Success, Equals, true)
+	c.Check(regex.FullMatch([]string{"long", "undone"}).Success, Equals, false)
+	c.Check(regex.FullMatch([]string{"the", "undo"}).Success, Equals, false)
+
+	// The registered class comes first
+	regex = compiler.MustCompile("[:longer(0):]")
+	c.Check(regex.FullMatch([]string{"word"}).Success, Equals, false)
+	c.Check(regex.FullMatch([]string{"registered"}).Success, Equals, true)
+	regex = compiler.MustCompile("[:prefix(z):]")
+	c.Check(regex.FullMatch([]string{"zoo"}).Success, Equals, false)
+	c.Check(regex.FullMatch([]string{"z"}).Success, Equals, true)
+
+	// The argument is everything between the parens
+	regex = compiler.MustCompile("[:prefix(a b):]")
+	c.Check(regex.FullMatch([]string{"a bc"}).Success, Equals, true)
+
+	// Through an import
+	importer := NewCompiler[string]()
+	importer.Import("w", compiler)
+	importer.Finalize()
+	c.Check(importer.ClassFactories(), DeepEquals, []string{"w.longer", "w.prefix"})
+	regex = importer.MustCompile("[:w.longer(2):] [:prefix(x):]")
+	c.Check(regex.FullMatch([]string{"abc", "xyz"}).Success, Equals, true)
+
+	_, err := importer.Build(ClassRef("longer(-1)"))
+	c.Check(err, ErrorMatches,
+		"Invalid argument '-1' to the class factory 'longer': -1 is negative")
+}
+
+// The factory is called once for each of its names in a compile
+func (s *MySuite) TestClassFactory03(c *C) {
+	calls := make(map[string]int)
+	compiler := NewCompiler[string]()
+	compiler.AddClassFactory("longer", func(arg string) (func(string) bool, error) {
+		calls[arg]++
+		return longerFactory(arg)
+	})
+	compiler.AddIdentity("a", "a")
+	compiler.AddIdentity("an", "an")
+	compiler.Finalize()
+
+	regex := compiler.MustCompile("[:longer(3):] ([:longer(3): || :a: || :an:] | [:longer(5):]) [:longer(3):]")
+	c.Check(calls, DeepEquals, map[string]int{"3": 1, "5": 1})
+	c.Check(regex.FullMatch([]string{"long", "an", "words"}).Success, Equals, true)
+
+	calls = make(map[string]int)
+	compiler.MustBuild(Seq(ClassRef("longer(3)"), Or(ClassRef("longer(3)"), IdentityRef("a"))))
+	c.Check(calls, DeepEquals, map[string]int{"3": 1})
+
+	// Each compile calls it again
+	compiler.MustCompile("[:longer(5):]")
+	c.Check(calls, DeepEquals, map[string]int{"3": 1, "5": 1})
+}
+
+// Bad arguments are reported at their positions
+func (s *MySuite) TestClassFactory02(c *C) {
+	compiler := NewCompiler[string]()
+	compiler.AddClassFactory("longer", longerFactory)
+	compiler.Finalize()
+
+	_, err := compiler.Compile("[:longer(2):] [! :longer(x):]")
+	c.Assert(err, FitsTypeOf, &SyntaxError{})
+	serr := err.(*SyntaxError)
+	c.Check(serr.Code, Equals, ErrInvalidArgument)
+	c.Check(serr.ByteOffset, Equals, 25)
+	c.Check(serr, ErrorMatches,
+		"Invalid argument 'x' to the class factory 'longer' at pos 25: .*invalid syntax")
+
+	_, err = compiler.Compile("[:longer(2): && :longer(-3):]")
+	c.Assert(err, FitsTypeOf, &SyntaxError{})
+	serr = err.(*SyntaxError)
+	c.Check(serr.Code, Equals, ErrInvalidArgument)
+	c.Check(serr.ByteOffset, Equals, 24)
+	c.Check(serr, ErrorMatches,
+		"Parsing class string at pos 1: Invalid argument '-3' to the class factory 'longer' at pos 23: -3 is negative")
+
+	_, err = compiler.Compile("[:shorter(2):]")
+	c.Check(err, ErrorMatches, "No such class or identity name 'shorter\\(2\\)' at pos 1")
+
+	_, err = compiler.Compile("[:longer(2:]")
+	c.Check(err, ErrorMatches, "The class name at pos 2 has '\\(' without a '\\)' at pos 8")
+
+	err = compiler.TryAddClassFactory("shorter", longerFactory)
+	c.Check(errors.Is(err, ErrFinalized), Equals, true)
+
+	other := NewCompiler[string]()
+	c.Check(other.TryAddClassFactory("longer", longerFactory), IsNil)
+	err = other.TryAddClassFactory("longer", longerFactory)
+	c.Check(errors.Is(err, ErrDuplicateName), Equals, true)
+	c.Check(err, ErrorMatches, "A class factory with name 'longer' already exists")
+	err = other.TryAddClassFactory("f()", longerFactory)
+	c.Check(errors.Is(err, ErrIllegalName), Equals, true)
+	c.Check(err, ErrorMatches, "The class factory 'f\\(\\)' can't have '\\(' or '\\)' in it")
+	err = other.TryAddField("f(x)", func(w string) string { return w })
+	c.Check(errors.Is(err, ErrIllegalName), Equals, true)
+	c.Check(err, ErrorMatches, "The field 'f\\(x\\)' can't have '\\(' or '\\)' in it")
+}
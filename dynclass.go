@@ -2,13 +2,12 @@
 
 package objregexp
 
-// Convert infix to postfix using Shuting yard algorithm:
-// https://en.wikipedia.org/wiki/Shunting_yard_algorithm
-
 import (
 	"fmt"
-	"sync"
-	"unicode"
+	"reflect"
+	"strings"
+
+	"github.com/gilramir/objregexp/syntax"
 )
 
 type dynClassT[T comparable] struct {
@@ -20,6 +19,7 @@ type dcopTypeT string
 const (
 	dcClass       dcopTypeT = "C"
 	dcIdentity              = "I"
+	dcIdentitySet           = "S"
 	dcNot                   = "!"
 	dcNoOp                  = "?"
 	dcJumpIfTrue            = "T"
@@ -35,7 +35,13 @@ type dynClassOpT[T comparable] struct {
 	// iObj is set if opType is dcIdentiy
 	iObj T
 
-	// cName is set if opType is dcClass or dcIdentity
+	// normalizer can be set if opType is dcIdentity
+	normalizer *normalizerT[T]
+
+	// iSet is set if opType is dcIdentitySet
+	iSet *identitySetT[T]
+
+	// cName is set if opType is dcClass, dcIdentity or dcIdentitySet
 	// This is only used for debugging.
 	cName string
 
@@ -44,114 +50,248 @@ type dynClassOpT[T comparable] struct {
 }
 
 func newDynClassT[T comparable](text string, compiler *Compiler[T]) (*dynClassT[T], error) {
-	s := &dynClassT[T]{
-		ops: make([]dynClassOpT[T], 0),
-	}
-	err := s.parse(text, compiler)
+	expr, err := syntax.ParseClass(text)
 	if err != nil {
 		return nil, err
 	}
-	return s, nil
+	names := newNameCache(compiler)
+	var nameErr error
+	walkClassNames(expr, func(ce *syntax.ClassExpr) {
+		if nameErr != nil {
+			return
+		}
+		// ce.Pos is at the ':'
+		ref, ambiguous := names.resolve(ce.Name)
+		if ambiguous != nil {
+			nameErr = newSyntaxError(text, ce.Pos+1, ErrAmbiguousName,
+				"Class :%s: at pos %d is ambiguous; it is in the imports %s",
+				ce.Name, ce.Pos+1, strings.Join(ambiguous, ", "))
+		} else if ref.err != nil {
+			factory, arg, argOffset, _ := syntax.SplitArg(ce.Name)
+			nameErr = newSyntaxError(text, ce.Pos+1+argOffset, ErrInvalidArgument,
+				"Invalid argument '%s' to the class factory '%s' at pos %d: %v",
+				arg, factory, ce.Pos+1+argOffset, ref.err)
+		} else if ref.kind == 0 {
+			nameErr = newSyntaxError(text, ce.Pos+1, ErrUnknownName,
+				"Class :%s: at pos %d is unknown", ce.Name, ce.Pos+1)
+		}
+	})
+	if nameErr != nil {
+		return nil, nameErr
+	}
+	return newDynClassFromExpr(expr, names), nil
 }
 
-type jmpInfoT struct {
-	insnPos   int
-	jmpTarget int
+// Make a dynClassT from a ClassExpr whose names are known to be in
+// the compiler, and aren't ambiguous.
+func newDynClassFromExpr[T comparable](expr *syntax.ClassExpr, names *nameCacheT[T]) *dynClassT[T] {
+	s := &dynClassT[T]{
+		ops: make([]dynClassOpT[T], 0),
+	}
+	s.gen(expr, names)
+	return s
 }
 
-func (s *dynClassT[T]) parse(text string, compiler *Compiler[T]) error {
-
-	// Produce a slice of postfix-ordered tokens
-	tokens, err := s.tokenize(text)
-	if err != nil {
-		return err
+// Call f on each ClassName in the expression
+func walkClassNames(expr *syntax.ClassExpr, f func(*syntax.ClassExpr)) {
+	if expr.Op == syntax.ClassName {
+		f(expr)
 	}
-	for ti, tok := range tokens {
-		dlog.Printf("token #%d: %+v\n", ti, tok)
+	for _, sub := range expr.Sub {
+		walkClassNames(sub, f)
 	}
+}
 
-	// convert to opcodes
-	ops := make([]dynClassOpT[T], 0, len(tokens))
-
-	jmpInfos := make([]jmpInfoT, 0)
-
-	// Key = target, Value = pos
-	jmpAt := make(map[int]int)
-
-	for ti, tok := range tokens {
-		op := dynClassOpT[T]{}
-
-		switch tok.ttype {
-		case dctClass:
-
-			/*
-				if tok.name[0] != ':' {
-					panic(fmt.Sprintf("Token %s doesn't begin with :", tok.name))
-				}
-				if tok.name[len(tok.name)-1] != ':' {
-					panic(fmt.Sprintf("Token %s doesn't end with :", tok.name))
+// Append the opcodes for the expression. An && stops at the
+// first false operand, and an || at the first true operand, by
+// jumping to the NoOp after the last operand.
+func (s *dynClassT[T]) gen(expr *syntax.ClassExpr, names *nameCacheT[T]) {
+	switch expr.Op {
+	case syntax.ClassName:
+		op := dynClassOpT[T]{cName: expr.Name}
+		ref, _ := names.resolve(expr.Name)
+		switch ref.kind {
+		case ccClass, ccIdentitySet:
+			op.opType = dcClass
+			op.oClass = ref.class
+		case ccIdentity:
+			op.opType = dcIdentity
+			op.iObj = ref.identity
+			op.normalizer = ref.normalizer
+		default:
+			panic(fmt.Sprintf("Unexpected class name %s", expr.Name))
+		}
+		s.ops = append(s.ops, op)
+
+	case syntax.ClassNot:
+		s.gen(expr.Sub[0], names)
+		s.ops = append(s.ops, dynClassOpT[T]{opType: dcNot})
+
+	case syntax.ClassAnd, syntax.ClassOr:
+		var jmpType dcopTypeT = dcJumpIfFalse
+		jmps := make([]int, 0, len(expr.Sub))
+		subs := expr.Sub
+		if expr.Op == syntax.ClassOr {
+			jmpType = dcJumpIfTrue
+			// The identities are tested with one map lookup
+			// before the other operands. The order doesn't
+			// matter, as none of the tests have side-effects.
+			var set *identitySetT[T]
+			set, subs = splitIdentities(expr.Sub, names)
+			if set != nil {
+				s.ops = append(s.ops, dynClassOpT[T]{opType: dcIdentitySet,
+					iSet: set, cName: set.names})
+				if len(subs) > 0 {
+					jmps = append(jmps, len(s.ops))
+					s.ops = append(s.ops, dynClassOpT[T]{opType: jmpType})
 				}
-				// We konw that each ":" is only 1 byte long
-				name := tok.name[1 : len(tok.name)-2]
-			*/
-			name := tok.name
-
-			ctype, has := compiler.namespace[name]
-			if !has {
-				return fmt.Errorf("Class :%s: at pos %d is unknown",
-					name, tok.pos)
 			}
-			switch ctype {
-			case ccClass:
-				op.opType = dcClass
-				op.oClass = compiler.classMap[tok.name]
-			case ccIdentity:
-				op.opType = dcIdentity
-				op.iObj = compiler.identityObj[tok.name]
-			default:
-				panic(fmt.Sprintf("Unexpected class type %v for %s", tok.ttype, name))
+		}
+		for i, sub := range subs {
+			s.gen(sub, names)
+			if i < len(subs)-1 {
+				jmps = append(jmps, len(s.ops))
+				s.ops = append(s.ops, dynClassOpT[T]{opType: jmpType})
 			}
-			op.cName = name
+		}
+		// Fix up the jumps
+		for _, insnPos := range jmps {
+			s.ops[insnPos].jmpTo = len(s.ops)
+		}
+		s.ops = append(s.ops, dynClassOpT[T]{opType: dcNoOp})
 
-		case dctNot:
-			op.opType = dcNot
+	default:
+		panic(fmt.Sprintf("Unexpected class op %v", expr.Op))
+	}
+}
 
-		case dctNoOp:
-			op.opType = dcNoOp
-			if tok.jmpTarget > 0 {
-				jmpAt[tok.jmpTarget] = ti
-			}
+// The fewest identities in an || that are put into a map
+const minIdentitySet = 2
 
-		case dctJumpIfFalse:
-			op.opType = dcJumpIfFalse
-			if tok.jmpTarget > 0 {
-				jmpInfos = append(jmpInfos, jmpInfoT{insnPos: ti, jmpTarget: tok.jmpTarget})
-			}
+// Identities which are tested with one map lookup, or, if T can't
+// always be looked up in a map, one at a time
+type identitySetT[T comparable] struct {
+	// The identities, which are already normalized. list is used
+	// instead of set if T can't always be looked up in a map.
+	set  map[T]struct{}
+	list []T
+
+	// The normalizer that all of the identities share
+	normalizer *normalizerT[T]
 
-		case dctJumpIfTrue:
-			op.opType = dcJumpIfTrue
-			if tok.jmpTarget > 0 {
-				jmpInfos = append(jmpInfos, jmpInfoT{insnPos: ti, jmpTarget: tok.jmpTarget})
+	// The identity names, for debugging
+	names string
+}
+
+// Make a set of the keys, which are already normalized. The normalizer
+// is applied to the objects which are tested against them. hashable
+// is what alwaysHashable returns for T.
+func newIdentitySet[T comparable](keys []T, normalizer *normalizerT[T], hashable bool) *identitySetT[T] {
+	s := &identitySetT[T]{normalizer: normalizer}
+	if !hashable {
+		for _, k := range keys {
+			// A key which holds a slice or a map is never
+			// equal to anything, and comparing it panics
+			if reflect.ValueOf(&k).Elem().Comparable() {
+				s.list = append(s.list, k)
 			}
+		}
+		return s
+	}
+	s.set = make(map[T]struct{}, len(keys))
+	for _, k := range keys {
+		s.set[k] = struct{}{}
+	}
+	return s
+}
 
-		default:
-			panic(fmt.Sprintf("Unexpected token type %v", tok.ttype))
+func (s *identitySetT[T]) has(o T) bool {
+	o = s.normalizer.apply(o)
+	if s.set != nil {
+		_, has := s.set[o]
+		return has
+	}
+	for _, k := range s.list {
+		if k == o {
+			return true
 		}
-		ops = append(ops, op)
 	}
+	return false
+}
 
-	// Fix up the jumps
-	for _, ji := range jmpInfos {
+// Can every value of T be looked up in a map? A value of an interface
+// type, or of a struct or array with one in it, can hold a slice or a
+// map, and looking that up panics, where "==" would not.
+func alwaysHashable[T comparable]() bool {
+	return typeAlwaysHashable(reflect.TypeOf((*T)(nil)).Elem())
+}
 
-		jmpToPos := jmpAt[ji.jmpTarget]
-		if jmpToPos == 0 {
-			panic(fmt.Sprintf("jmp at pos %d to target %d not found", ji.insnPos, ji.jmpTarget))
+func typeAlwaysHashable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Interface:
+		return false
+	case reflect.Array:
+		return typeAlwaysHashable(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !typeAlwaysHashable(t.Field(i).Type) {
+				return false
+			}
 		}
-		ops[ji.insnPos].jmpTo = jmpToPos
 	}
+	return true
+}
 
-	s.ops = ops
-	return nil
+// Split the operands of an || into the identities, as a set, and the
+// rest. Only the identities with the same normalizer as the first one
+// are put in the set. If there are too few of them, or if T can't
+// always be looked up in a map, the set is nil, and all of the
+// operands are returned. Returns set, rest
+func splitIdentities[T comparable](subs []*syntax.ClassExpr, names *nameCacheT[T]) (*identitySetT[T], []*syntax.ClassExpr) {
+	if !names.compiler.hashable {
+		return nil, subs
+	}
+	rest := make([]*syntax.ClassExpr, 0, len(subs))
+	objects := make([]T, 0, len(subs))
+	setNames := make([]string, 0, len(subs))
+	var normalizer *normalizerT[T]
+	for _, sub := range subs {
+		if sub.Op == syntax.ClassName {
+			ref, _ := names.resolve(sub.Name)
+			if ref.kind == ccIdentity && (len(objects) == 0 || ref.normalizer == normalizer) {
+				normalizer = ref.normalizer
+				objects = append(objects, ref.identity)
+				setNames = append(setNames, ":"+sub.Name+":")
+				continue
+			}
+		}
+		rest = append(rest, sub)
+	}
+	if len(objects) < minIdentitySet {
+		return nil, subs
+	}
+	set := newIdentitySet(objects, normalizer, true)
+	set.names = strings.Join(setNames, " || ")
+	return set, rest
+}
+
+// If the expression is an || of only identities, or the negation of
+// one, returns the set, and whether it is negated. Otherwise the set
+// is nil.
+func identitySetExpr[T comparable](expr *syntax.ClassExpr, names *nameCacheT[T]) (*identitySetT[T], bool) {
+	negation := false
+	if expr.Op == syntax.ClassNot {
+		negation = true
+		expr = expr.Sub[0]
+	}
+	if expr.Op != syntax.ClassOr {
+		return nil, false
+	}
+	set, rest := splitIdentities(expr.Sub, names)
+	if len(rest) > 0 {
+		return nil, false
+	}
+	return set, negation
 }
 
 func (s *dynClassT[T]) Matches(ch T) bool {
@@ -166,7 +306,10 @@ func (s *dynClassT[T]) Matches(ch T) bool {
 			accum = op.oClass.Matches(ch)
 			pos++
 		case dcIdentity:
-			accum = op.iObj == ch
+			accum = op.iObj == op.normalizer.apply(ch)
+			pos++
+		case dcIdentitySet:
+			accum = op.iSet.has(ch)
 			pos++
 		case dcNot:
 			accum = !accum
@@ -191,360 +334,3 @@ func (s *dynClassT[T]) Matches(ch T) bool {
 	}
 	return accum
 }
-
-func (s *dynClassT[T]) tokenize(input string) ([]dcTokenT, error) {
-	var pstate dcParserStateT[T]
-	pstate.Initialize(input)
-
-	// Produce a slice of postfix-ordered tokens
-	tokens, err := pstate.parse()
-	return tokens, err
-}
-
-type dcTokenTypeT string
-
-// The token types
-const (
-	dctError       dcTokenTypeT = "E"
-	dctClass                    = "C" // :alpha:
-	dctNoOp                     = "?" // Short-circuit jump target for && and ||
-	dctNot                      = "!"
-	dctLParen                   = "("
-	dctRParen                   = ")"
-	dctJumpIfFalse              = "F" // short-circuit for &&
-	dctJumpIfTrue               = "T" // short-circuit for ||
-)
-
-const (
-	notPrecedence = 1
-	andPrecedence = 2
-	orPrecedence  = 3
-)
-
-type dcTokenT struct {
-	ttype dcTokenTypeT
-
-	// position in the dynamic class string; used for reporting syntax
-	// errors to the user.
-	pos int
-
-	// For dctClass, name is the name of the class
-	name string
-
-	// where to jump to for JumpIfFalse and JumpIfTrue
-	// if on an NoOp operand, and jmpTarget is set, the next insns
-	// is the target
-	jmpTarget int
-
-	precedence int
-
-	// An error caught during parsing, to cause the
-	// parse to fail, and to be reported to the user.
-	err error
-}
-
-type dcParserStateT[T comparable] struct {
-	input runeBufferT
-	ops   []dynClassOpT[T]
-	stack stackT[dcTokenT]
-
-	nextJumpTarget int
-
-	tokenChan chan dcTokenT
-	wg        sync.WaitGroup
-
-	// Was a dctError emitted?
-	emittedError bool
-}
-
-func (s *dcParserStateT[T]) Initialize(input string) {
-	s.input.Initialize(input)
-	s.input.runeErrorCb = s.emitRuneError
-	s.tokenChan = make(chan dcTokenT)
-	s.stack = NewStack[dcTokenT]()
-}
-
-func (s *dcParserStateT[T]) parse() ([]dcTokenT, error) {
-	tokens := make([]dcTokenT, 0)
-	go s.goparse()
-
-	for token := range s.tokenChan {
-		switch token.ttype {
-		case dctError:
-			// There better not be any tokens being written
-			// to the channel, and thereby not closing the
-			// channel, and leaving the goroutine still running,
-			// otherwise we'll be waiting here forever.
-			s.wg.Wait()
-			return nil, token.err
-		default:
-			tokens = append(tokens, token)
-		}
-	}
-	s.wg.Wait()
-
-	// Here can we clean up the start/end register tokens?
-	//printTokens(tokens)
-	//dlog.Printf("")
-
-	return tokens, nil
-
-}
-
-func (s *dcParserStateT[T]) goparse() {
-	s.wg.Add(1)
-	defer s.wg.Done()
-	defer close(s.tokenChan)
-
-	allowClass := true
-	allowAndOr := false
-
-	for {
-		// Get the next rune
-		ok, r, eof := s.input.getNextRune()
-		if !ok {
-			return
-		}
-		if eof {
-			break
-		}
-
-		switch r {
-		case ' ', '\t', '\n':
-			continue
-
-		case '(':
-			s.parseLParen()
-			allowClass = true
-			allowAndOr = false
-
-		case '|':
-			if allowAndOr {
-				s.parsePipe()
-				allowClass = true
-			} else {
-				s.emitErrorf("|| is not allowed at pos %d", s.input.pos)
-				return
-			}
-
-		case '&':
-			if allowAndOr {
-				s.parseAmpersand()
-				allowClass = true
-			} else {
-				s.emitErrorf("&& is not allowed at pos %d", s.input.pos)
-				return
-			}
-
-		case ')':
-			s.parseRParen()
-			allowClass = false
-			allowAndOr = true
-
-		case ':':
-			if allowClass {
-				s.parseColon()
-				allowClass = false
-				allowAndOr = true
-			} else {
-				s.emitErrorf("Class name not allowed at pos %d", s.input.pos)
-				return
-			}
-
-		case '!':
-			s.parseBang()
-			allowClass = true
-			allowAndOr = false
-
-		default:
-			s.emitErrorf("Syntax error at pos %d starting with '%c'", s.input.pos, r)
-			return
-		}
-
-		if s.emittedError {
-			return
-		}
-	}
-
-	for s.stack.Size() > 0 {
-		tok := s.stack.Top()
-		if tok.ttype == dctLParen {
-			s.emitErrorf("Unbalanced left paren starting at pos %d", tok.pos)
-			return
-		} else {
-			s.tokenChan <- tok
-			s.stack.Pop()
-		}
-	}
-}
-
-func (s *dcParserStateT[T]) parseLParen() {
-	s.stack.Push(dcTokenT{
-		ttype: dctLParen,
-		pos:   s.input.pos,
-	})
-}
-func (s *dcParserStateT[T]) parseRParen() {
-	for tok := s.stack.Top(); tok.ttype != dctLParen; {
-		s.tokenChan <- tok
-		s.stack.Pop()
-		if s.stack.Size() == 0 {
-			s.emitErrorf("Unbalanced right paren at pos %d", s.input.pos)
-			return
-		}
-		tok = s.stack.Top()
-	}
-	// the tok on the top of a stack was a LParen; pop & discard it
-	s.stack.Pop()
-}
-
-func (s *dcParserStateT[T]) parsePipe() {
-	startPos := s.input.pos
-	ok, r, eof := s.input.getNextRune()
-	if eof {
-		s.emitUnexpectedEOF()
-		return
-	}
-	if !ok {
-		return
-	}
-	if r != '|' {
-		s.emitErrorf("Expected 2 |'s at pos %d", startPos)
-		return
-	}
-
-	s.nextJumpTarget++
-	jmpTarget := s.nextJumpTarget
-
-	for s.stack.Size() > 0 {
-		tok := s.stack.Top()
-		if tok.ttype == dctLParen || tok.precedence >= orPrecedence {
-			break
-		} else {
-			s.tokenChan <- tok
-			s.stack.Pop()
-		}
-	}
-	s.tokenChan <- dcTokenT{
-		ttype:     dctJumpIfTrue,
-		pos:       startPos,
-		jmpTarget: jmpTarget,
-	}
-
-	s.stack.Push(dcTokenT{
-		ttype:      dctNoOp,
-		pos:        s.input.pos,
-		jmpTarget:  jmpTarget,
-		precedence: orPrecedence,
-	})
-}
-
-func (s *dcParserStateT[T]) parseAmpersand() {
-	startPos := s.input.pos
-	ok, r, eof := s.input.getNextRune()
-	if eof {
-		s.emitUnexpectedEOF()
-		return
-	}
-	if !ok {
-		return
-	}
-	if r != '&' {
-		s.emitErrorf("Expected 2 &'s at pos %d", startPos)
-		return
-	}
-
-	s.nextJumpTarget++
-	jmpTarget := s.nextJumpTarget
-
-	for s.stack.Size() > 0 {
-		tok := s.stack.Top()
-		if tok.ttype == dctLParen || tok.precedence >= andPrecedence {
-			break
-		} else {
-			s.tokenChan <- tok
-			s.stack.Pop()
-		}
-	}
-	s.tokenChan <- dcTokenT{
-		ttype:     dctJumpIfFalse,
-		pos:       startPos,
-		jmpTarget: jmpTarget,
-	}
-
-	s.stack.Push(dcTokenT{
-		ttype:      dctNoOp,
-		pos:        s.input.pos,
-		jmpTarget:  jmpTarget,
-		precedence: andPrecedence,
-	})
-}
-func (s *dcParserStateT[T]) parseBang() {
-	for s.stack.Size() > 0 {
-		tok := s.stack.Top()
-		if tok.ttype == dctLParen || tok.precedence >= notPrecedence {
-			break
-		} else {
-			s.tokenChan <- tok
-			s.stack.Pop()
-		}
-	}
-
-	s.stack.Push(dcTokenT{
-		ttype:      dctNot,
-		pos:        s.input.pos,
-		precedence: notPrecedence,
-	})
-}
-func (s *dcParserStateT[T]) parseColon() {
-	// Read rune names until the ending colon
-	nameRunes := make([]rune, 0, 20)
-
-	classPos := s.input.pos
-	for {
-		ok, r, eof := s.input.getNextRune()
-		if eof {
-			s.emitUnexpectedEOF()
-			return
-		}
-		if !ok {
-			return
-		}
-
-		// Allow anything except non-visible code point glyphs.
-		// But do allow spaces
-		if !unicode.IsGraphic(r) && r != ' ' {
-			s.emitErrorf("The class name starting at pos %d has a non-graphic Unicode code point in it",
-				classPos)
-			return
-		}
-
-		if r == ':' {
-			break
-		}
-		nameRunes = append(nameRunes, r)
-	}
-
-	s.tokenChan <- dcTokenT{
-		ttype: dctClass,
-		pos:   classPos,
-		name:  string(nameRunes),
-	}
-
-}
-
-func (s *dcParserStateT[T]) emitErrorf(f string, args ...any) {
-	s.tokenChan <- dcTokenT{
-		ttype: dctError,
-		pos:   s.input.pos,
-		err:   fmt.Errorf(f, args...),
-	}
-	s.emittedError = true
-}
-
-func (s *dcParserStateT[T]) emitRuneError() {
-	s.emitErrorf("Bytes starting at position %d aren't valid UTF-8", s.input.pos)
-}
-func (s *dcParserStateT[T]) emitUnexpectedEOF() {
-	s.emitErrorf("Unexpected end of string")
-}
@@ -0,0 +1,75 @@
+// Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
+
+package objregexp
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gilramir/objregexp/syntax"
+)
+
+// A SyntaxError is returned by Compile when the regex can't be compiled.
+// The offsets locate the problem in the regex.
+type SyntaxError = syntax.Error
+
+// SyntaxErrors is returned by Compile when the regex has more than one
+// problem. They are in the order of their offsets.
+type SyntaxErrors = syntax.Errors
+
+// The kind of problem a SyntaxError reports
+type ErrorCode = syntax.ErrorCode
+
+const (
+	ErrUnexpectedRune    = syntax.ErrUnexpectedRune    // a rune that can't be there
+	ErrUnexpectedEOF     = syntax.ErrUnexpectedEOF     // the regex ended too soon
+	ErrInvalidUTF8       = syntax.ErrInvalidUTF8       // bytes that aren't UTF-8
+	ErrMissingOperand    = syntax.ErrMissingOperand    // an operator with nothing to operate on
+	ErrUnbalancedParen   = syntax.ErrUnbalancedParen   // a paren without its partner
+	ErrInvalidGroup      = syntax.ErrInvalidGroup      // a bad "(?" or group name
+	ErrInvalidRepetition = syntax.ErrInvalidRepetition // a bad {m,n}
+	ErrInvalidBackref    = syntax.ErrInvalidBackref    // a backreference to a bad group
+	ErrNestedAssertion   = syntax.ErrNestedAssertion   // an assertion inside a lookbehind
+	ErrUnknownName       = syntax.ErrUnknownName       // an unregistered class or identity
+	ErrInvalidName       = syntax.ErrInvalidName       // a class name with bad runes in it
+	ErrInvalidClass      = syntax.ErrInvalidClass      // a bracket that isn't [:name:]
+	ErrAmbiguousName     = syntax.ErrAmbiguousName     // a name in more than one import
+	ErrInvalidArgument   = syntax.ErrInvalidArgument   // an argument that a class factory rejected
+)
+
+func newSyntaxError(pattern string, byteOffset int, code ErrorCode, f string, args ...any) *SyntaxError {
+	return syntax.NewError(pattern, byteOffset, code, fmt.Sprintf(f, args...))
+}
+
+// The problems that the Try methods of Compiler, and NewTypeClass, can
+// report. They are wrapped in a *NameError, so use errors.Is to check
+// for them.
+var (
+	ErrDuplicateName = errors.New("The name is already registered")
+	ErrFinalized     = errors.New("The objregexp.Compiler is already finalized")
+	ErrNotFinalized  = errors.New("The objregexp.Compiler is not finalized")
+	ErrIllegalName   = errors.New("The name can't be used in a regex")
+
+	ErrNotInterface   = errors.New("The object type is not an interface type")
+	ErrImpossibleType = errors.New("The type does not implement the object type")
+)
+
+// A NameError is returned when a class or identity can't be registered.
+type NameError struct {
+	// The name of the class or identity
+	Name string
+
+	// ErrDuplicateName, ErrFinalized, ErrNotFinalized, ErrIllegalName,
+	// ErrNotInterface, or ErrImpossibleType
+	Err error
+
+	msg string
+}
+
+func (s *NameError) Error() string {
+	return s.msg
+}
+
+func (s *NameError) Unwrap() error {
+	return s.Err
+}
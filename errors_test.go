@@ -0,0 +1,120 @@
+// Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
+
+package objregexp
+
+import (
+	"errors"
+
+	. "github.com/gilramir/objregexp/internal/check"
+)
+
+func (s *MySuite) TestSyntaxError01(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.Finalize()
+
+	_, err := compiler.Compile("[:vowel:] [:vowl:]")
+	c.Assert(err, NotNil)
+
+	var serr *SyntaxError
+	c.Assert(errors.As(err, &serr), Equals, true)
+	c.Check(serr.Code, Equals, ErrUnknownName)
+	c.Check(serr.Pattern, Equals, "[:vowel:] [:vowl:]")
+	c.Check(serr.Offset, Equals, 11)
+	c.Check(serr.ByteOffset, Equals, 11)
+	c.Check(serr.Caret(), Equals, "[:vowel:] [:vowl:]\n           ^")
+
+	// The offsets are counted differently with multi-byte runes,
+	// and only the line with the problem is shown
+	_, err = compiler.Compile("# é\n\t[:vowel:] )")
+	c.Assert(err, NotNil)
+	c.Assert(errors.As(err, &serr), Equals, true)
+	c.Check(serr.Code, Equals, ErrUnbalancedParen)
+	c.Check(serr.Offset, Equals, 15)
+	c.Check(serr.ByteOffset, Equals, 16)
+	c.Check(serr.Caret(), Equals, "\t[:vowel:] )\n\t          ^")
+
+	// A '|' with nothing after it
+	_, err = compiler.Compile("[:vowel:] |")
+	c.Assert(errors.As(err, &serr), Equals, true)
+	c.Check(serr.Code, Equals, ErrMissingOperand)
+	c.Check(serr.ByteOffset, Equals, 10)
+
+	_, err = compiler.Compile("[:vowel:]")
+	c.Assert(err, IsNil)
+}
+
+// Errors in a dynamic class are in the position of the whole regex
+func (s *MySuite) TestSyntaxError02(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	_, err := compiler.Compile("[:digit:] [:vowel: && :vowl:]")
+	c.Assert(err, NotNil)
+
+	var serr *SyntaxError
+	c.Assert(errors.As(err, &serr), Equals, true)
+	c.Check(serr.Code, Equals, ErrUnknownName)
+	c.Check(serr.Msg, Equals, "Parsing class string at pos 11: Class :vowl: at pos 12 is unknown")
+	c.Check(serr.Caret(), Equals, "[:digit:] [:vowel: && :vowl:]\n                       ^")
+
+	_, err = compiler.Compile("[:vowel: & :digit:]")
+	c.Assert(errors.As(err, &serr), Equals, true)
+	c.Check(serr.Code, Equals, ErrUnexpectedRune)
+	c.Check(serr.Caret(), Equals, "[:vowel: & :digit:]\n          ^")
+}
+
+// Every error is reported, not just the first
+func (s *MySuite) TestSyntaxErrors01(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	_, err := compiler.Compile(`
+		[:vowl:]
+		(?P<>[:digit:])
+		[:vowel]
+		[:x: || :digit:]
+		(?P<v>.) (?P<v>.)`)
+	c.Assert(err, NotNil)
+	c.Check(err, ErrorMatches, `No such class or identity name 'vowl' at pos 4 \(and 4 more errors\)`)
+
+	errs, ok := err.(SyntaxErrors)
+	c.Assert(ok, Equals, true)
+	c.Assert(len(errs), Equals, 5)
+	c.Check(errs[0].Code, Equals, ErrUnknownName)
+	c.Check(errs[1].Code, Equals, ErrInvalidGroup)
+	c.Check(errs[2].Code, Equals, ErrInvalidClass)
+	c.Check(errs[3].Code, Equals, ErrUnknownName)
+	c.Check(errs[3].Caret(), Equals, "\t\t[:x: || :digit:]\n\t\t  ^")
+	c.Check(errs[4].Code, Equals, ErrInvalidGroup)
+
+	// errors.As finds the first one
+	var serr *SyntaxError
+	c.Assert(errors.As(err, &serr), Equals, true)
+	c.Check(serr, Equals, errs[0])
+
+	// errors.Is looks at each of them
+	c.Check(errors.Is(err, errs[3]), Equals, true)
+	c.Check(errors.Is(err, ErrDuplicateName), Equals, false)
+
+	// An error that stops the parse still lets the
+	// unknown names be reported
+	_, err = compiler.Compile("[:vowl:] (")
+	errs, ok = err.(SyntaxErrors)
+	c.Assert(ok, Equals, true)
+	c.Assert(len(errs), Equals, 2)
+	c.Check(errs[0].Code, Equals, ErrUnknownName)
+	c.Check(errs[1].Code, Equals, ErrUnexpectedEOF)
+
+	// A single error is not in a list
+	_, err = compiler.Compile("[:vowl:]")
+	_, ok = err.(*SyntaxError)
+	c.Check(ok, Equals, true)
+}
@@ -1,6 +1,9 @@
 module github.com/gilramir/objregexp
 
-go 1.19
+// Go 1.20 is the first version in which interface types, like any,
+// satisfy comparable, so that there can be a Compiler[any], and in
+// which reflect.Value has Comparable.
+go 1.20
 
 require (
 	github.com/kr/pretty v0.2.1 // indirect
@@ -5,7 +5,7 @@ package objregexp
 import (
 	"testing"
 
-	. "gopkg.in/check.v1"
+	. "github.com/gilramir/objregexp/internal/check"
 )
 
 // Hook up gocheck into the "go test" runner
@@ -0,0 +1,45 @@
+// Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
+
+// Package check is gopkg.in/check.v1, without its Not, for the tests of
+// objregexp. They dot-import gocheck, and its Not would collide with
+// objregexp.Not.
+package check
+
+import (
+	"testing"
+
+	gocheck "gopkg.in/check.v1"
+)
+
+type (
+	C                = gocheck.C
+	Checker          = gocheck.Checker
+	CheckerInfo      = gocheck.CheckerInfo
+	CommentInterface = gocheck.CommentInterface
+)
+
+var (
+	DeepEquals   = gocheck.DeepEquals
+	Equals       = gocheck.Equals
+	ErrorMatches = gocheck.ErrorMatches
+	FitsTypeOf   = gocheck.FitsTypeOf
+	HasLen       = gocheck.HasLen
+	Implements   = gocheck.Implements
+	IsNil        = gocheck.IsNil
+	Matches      = gocheck.Matches
+	NotNil       = gocheck.NotNil
+	PanicMatches = gocheck.PanicMatches
+	Panics       = gocheck.Panics
+)
+
+func Commentf(format string, args ...interface{}) CommentInterface {
+	return gocheck.Commentf(format, args...)
+}
+
+func Suite(suite interface{}) interface{} {
+	return gocheck.Suite(suite)
+}
+
+func TestingT(t *testing.T) {
+	gocheck.TestingT(t)
+}
@@ -0,0 +1,275 @@
+// Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
+
+package objregexp
+
+// The objects that a KeyCompiler's regexes work on. The key is what
+// identities and backreferences compare, and obj is what classes are
+// given. For an identity, obj is nil.
+type keyedT[T any, K comparable] struct {
+	key K
+	obj *T
+}
+
+// A KeyCompiler is like a Compiler, but for objects which can't be
+// compared with "==", like structs with slices or maps in them. A key
+// function maps each object to a comparable key, and identities and
+// backreferences compare objects by their keys. Classes are given the
+// objects themselves.
+//
+// Regexes are compiled with the same syntax, and the same engine, as
+// with a Compiler, but each match first makes a slice of the keys of
+// the input, so use a Compiler if the objects are comparable.
+type KeyCompiler[T any, K comparable] struct {
+	compiler *Compiler[keyedT[T, K]]
+	key      func(T) K
+}
+
+// Instantiates and initializes a new KeyCompiler, which compares
+// objects by the keys that the key function returns.
+func NewKeyCompiler[T any, K comparable](key func(T) K) *KeyCompiler[T, K] {
+	s := &KeyCompiler[T, K]{
+		compiler: NewCompiler[keyedT[T, K]](),
+		key:      key,
+	}
+	// The objects are compared without their pointers
+	s.compiler.SetNormalizer(func(k keyedT[T, K]) keyedT[T, K] {
+		return keyedT[T, K]{key: k.key}
+	})
+	return s
+}
+
+// Like Compiler.NewChild
+func (s *KeyCompiler[T, K]) NewChild() *KeyCompiler[T, K] {
+	return &KeyCompiler[T, K]{
+		compiler: s.compiler.NewChild(),
+		key:      s.key,
+	}
+}
+
+// Like Compiler.Import
+func (s *KeyCompiler[T, K]) Import(prefix string, other *KeyCompiler[T, K]) {
+	s.compiler.Import(prefix, other.compiler)
+}
+
+// Like Compiler.TryImport
+func (s *KeyCompiler[T, K]) TryImport(prefix string, other *KeyCompiler[T, K]) error {
+	return s.compiler.TryImport(prefix, other.compiler)
+}
+
+// Like Compiler.Finalize
+func (s *KeyCompiler[T, K]) Finalize() {
+	s.compiler.Finalize()
+}
+
+// Like Compiler.TryFinalize
+func (s *KeyCompiler[T, K]) TryFinalize() error {
+	return s.compiler.TryFinalize()
+}
+
+// Make the class that the engine uses from the predicate
+func keyedClass[T any, K comparable](name string, predicate func(T) bool) *Class[keyedT[T, K]] {
+	return &Class[keyedT[T, K]]{
+		Name: name,
+		Matches: func(k keyedT[T, K]) bool {
+			return predicate(*k.obj)
+		},
+	}
+}
+
+// Like Compiler.MakeClass
+func (s *KeyCompiler[T, K]) MakeClass(name string, predicate func(T) bool) {
+	s.compiler.AddClass(keyedClass[T, K](name, predicate))
+}
+
+// Like Compiler.TryMakeClass
+func (s *KeyCompiler[T, K]) TryMakeClass(name string, predicate func(T) bool) error {
+	return s.compiler.TryAddClass(keyedClass[T, K](name, predicate))
+}
+
+// Make an accessor that the engine uses from the accessor
+func keyedAccessor[T any, K comparable](accessor func(T) string) func(keyedT[T, K]) string {
+	return func(k keyedT[T, K]) string {
+		return accessor(*k.obj)
+	}
+}
+
+// Like Compiler.AddField
+func (s *KeyCompiler[T, K]) AddField(field string, accessor func(T) string) {
+	s.compiler.AddField(field, keyedAccessor[T, K](accessor))
+}
+
+// Like Compiler.TryAddField
+func (s *KeyCompiler[T, K]) TryAddField(field string, accessor func(T) string) error {
+	return s.compiler.TryAddField(field, keyedAccessor[T, K](accessor))
+}
+
+// Like Compiler.MakeFieldClass
+func (s *KeyCompiler[T, K]) MakeFieldClass(name string, accessor func(T) string, values ...string) {
+	s.compiler.MakeFieldClass(name, keyedAccessor[T, K](accessor), values...)
+}
+
+// Like Compiler.TryMakeFieldClass
+func (s *KeyCompiler[T, K]) TryMakeFieldClass(name string, accessor func(T) string, values ...string) error {
+	return s.compiler.TryMakeFieldClass(name, keyedAccessor[T, K](accessor), values...)
+}
+
+// Like Compiler.AddClassFactory
+func (s *KeyCompiler[T, K]) AddClassFactory(name string, factory func(arg string) (func(T) bool, error)) {
+	s.compiler.AddClassFactory(name, keyedFactory[T, K](factory))
+}
+
+// Like Compiler.TryAddClassFactory
+func (s *KeyCompiler[T, K]) TryAddClassFactory(name string, factory func(arg string) (func(T) bool, error)) error {
+	return s.compiler.TryAddClassFactory(name, keyedFactory[T, K](factory))
+}
+
+// Make a factory that the engine uses from the factory
+func keyedFactory[T any, K comparable](factory func(string) (func(T) bool, error)) func(string) (func(keyedT[T, K]) bool, error) {
+	return func(arg string) (func(keyedT[T, K]) bool, error) {
+		predicate, err := factory(arg)
+		if err != nil {
+			return nil, err
+		}
+		return func(k keyedT[T, K]) bool {
+			return predicate(*k.obj)
+		}, nil
+	}
+}
+
+// Like Compiler.AddIdentity. The object matches the objects which
+// have the same key.
+func (s *KeyCompiler[T, K]) AddIdentity(name string, object T) {
+	s.compiler.AddIdentity(name, keyedT[T, K]{key: s.key(object)})
+}
+
+// Like Compiler.TryAddIdentity
+func (s *KeyCompiler[T, K]) TryAddIdentity(name string, object T) error {
+	return s.compiler.TryAddIdentity(name, keyedT[T, K]{key: s.key(object)})
+}
+
+// Like Compiler.AddIdentitySet
+func (s *KeyCompiler[T, K]) AddIdentitySet(name string, objects []T) {
+	s.compiler.AddIdentitySet(name, s.identities(objects))
+}
+
+// Like Compiler.TryAddIdentitySet
+func (s *KeyCompiler[T, K]) TryAddIdentitySet(name string, objects []T) error {
+	return s.compiler.TryAddIdentitySet(name, s.identities(objects))
+}
+
+func (s *KeyCompiler[T, K]) identities(objects []T) []keyedT[T, K] {
+	keyed := make([]keyedT[T, K], len(objects))
+	for i, o := range objects {
+		keyed[i] = keyedT[T, K]{key: s.key(o)}
+	}
+	return keyed
+}
+
+// Like Compiler.Names
+func (s *KeyCompiler[T, K]) Names() []NameInfo {
+	return s.compiler.Names()
+}
+
+// Like Compiler.Fields
+func (s *KeyCompiler[T, K]) Fields() []string {
+	return s.compiler.Fields()
+}
+
+// Like Compiler.ClassFactories
+func (s *KeyCompiler[T, K]) ClassFactories() []string {
+	return s.compiler.ClassFactories()
+}
+
+// Like Compiler.Lookup
+func (s *KeyCompiler[T, K]) Lookup(name string) (NameKind, bool) {
+	return s.compiler.Lookup(name)
+}
+
+// Like Compiler.Compile
+func (s *KeyCompiler[T, K]) Compile(text string) (*KeyRegexp[T, K], error) {
+	re, err := s.compiler.Compile(text)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyRegexp[T, K]{regexp: re, key: s.key}, nil
+}
+
+// Like Compiler.MustCompile
+func (s *KeyCompiler[T, K]) MustCompile(text string) *KeyRegexp[T, K] {
+	return &KeyRegexp[T, K]{regexp: s.compiler.MustCompile(text), key: s.key}
+}
+
+// Like Compiler.Build
+func (s *KeyCompiler[T, K]) Build(p Pattern) (*KeyRegexp[T, K], error) {
+	re, err := s.compiler.Build(p)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyRegexp[T, K]{regexp: re, key: s.key}, nil
+}
+
+// Like Compiler.MustBuild
+func (s *KeyCompiler[T, K]) MustBuild(p Pattern) *KeyRegexp[T, K] {
+	return &KeyRegexp[T, K]{regexp: s.compiler.MustBuild(p), key: s.key}
+}
+
+// The regex compiled by a KeyCompiler. Its methods are the same as
+// those of Regexp.
+type KeyRegexp[T any, K comparable] struct {
+	regexp *Regexp[keyedT[T, K]]
+	key    func(T) K
+}
+
+// Make the objects that the engine works on
+func (s *KeyRegexp[T, K]) keyed(input []T) []keyedT[T, K] {
+	keyed := make([]keyedT[T, K], len(input))
+	for i := range input {
+		keyed[i] = keyedT[T, K]{key: s.key(input[i]), obj: &input[i]}
+	}
+	return keyed
+}
+
+// Like Regexp.String
+func (s *KeyRegexp[T, K]) String() string {
+	return s.regexp.String()
+}
+
+// Like Regexp.Source
+func (s *KeyRegexp[T, K]) Source() string {
+	return s.regexp.Source()
+}
+
+// Like Regexp.Names
+func (s *KeyRegexp[T, K]) Names() []string {
+	return s.regexp.Names()
+}
+
+// Like Regexp.FullMatch
+func (s *KeyRegexp[T, K]) FullMatch(input []T) Match {
+	return s.regexp.FullMatch(s.keyed(input))
+}
+
+// Like Regexp.FullMatchAt
+func (s *KeyRegexp[T, K]) FullMatchAt(input []T, start int) Match {
+	return s.regexp.FullMatchAt(s.keyed(input), start)
+}
+
+// Like Regexp.Match
+func (s *KeyRegexp[T, K]) Match(input []T) Match {
+	return s.regexp.Match(s.keyed(input))
+}
+
+// Like Regexp.MatchAt
+func (s *KeyRegexp[T, K]) MatchAt(input []T, start int) Match {
+	return s.regexp.MatchAt(s.keyed(input), start)
+}
+
+// Like Regexp.Search
+func (s *KeyRegexp[T, K]) Search(input []T) Match {
+	return s.regexp.Search(s.keyed(input))
+}
+
+// Like Regexp.SearchAt
+func (s *KeyRegexp[T, K]) SearchAt(input []T, start int) Match {
+	return s.regexp.SearchAt(s.keyed(input), start)
+}
@@ -0,0 +1,153 @@
+// Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
+
+package objregexp
+
+import (
+	"strings"
+
+	. "github.com/gilramir/objregexp/internal/check"
+)
+
+// A token which can't be compared with "=="
+type tokenT struct {
+	word string
+	tags []string
+}
+
+func (s tokenT) hasTag(tag string) bool {
+	for _, t := range s.tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func tokens(text string) []tokenT {
+	words := strings.Fields(text)
+	toks := make([]tokenT, len(words))
+	for i, w := range words {
+		toks[i].word = w
+		if strings.ToUpper(w[:1]) == w[:1] {
+			toks[i].tags = []string{"title"}
+		}
+	}
+	return toks
+}
+
+// Compares tokens by their lower-cased words
+func tokenKey(t tokenT) string {
+	return strings.ToLower(t.word)
+}
+
+func (s *MySuite) TestKeyCompilerIdentityComparesKeys(c *C) {
+	compiler := NewKeyCompiler(tokenKey)
+	compiler.AddIdentity("the", tokenT{word: "the"})
+	compiler.AddIdentity("a", tokenT{word: "A", tags: []string{"det"}})
+	c.Check(compiler.TryAddIdentity("the", tokenT{}), ErrorMatches,
+		"An identity with name 'the' already exists")
+	compiler.Finalize()
+
+	re := compiler.MustCompile("[:the: || :a:]")
+	c.Check(re.String(), Equals, "[:the: || :a:]")
+	c.Check(re.FullMatch(tokens("THE")).Success, Equals, true)
+	c.Check(re.FullMatch(tokens("a")).Success, Equals, true)
+	c.Check(re.FullMatch(tokens("an")).Success, Equals, false)
+}
+
+func (s *MySuite) TestKeyCompilerIdentitySetComparesKeys(c *C) {
+	compiler := NewKeyCompiler(tokenKey)
+	compiler.AddIdentitySet("verb", []tokenT{{word: "sat"}, {word: "ran"}})
+	compiler.Finalize()
+
+	kind, ok := compiler.Lookup("verb")
+	c.Check(ok, Equals, true)
+	c.Check(kind, Equals, KindIdentitySet)
+
+	re := compiler.MustCompile("[:verb:]")
+	c.Check(re.Search(tokens("the Dog RAN")).Range, Equals, Range{2, 3})
+	c.Check(re.Search(tokens("the dog runs")).Success, Equals, false)
+}
+
+func (s *MySuite) TestKeyCompilerBackrefComparesKeys(c *C) {
+	compiler := NewKeyCompiler(tokenKey)
+	compiler.AddIdentitySet("verb", []tokenT{{word: "sat"}, {word: "ran"}})
+	compiler.Finalize()
+
+	re := compiler.MustCompile("(.) [:verb:] \\1")
+	c.Check(re.FullMatch(tokens("Dog ran dog")).Success, Equals, true)
+	c.Check(re.FullMatch(tokens("Dog ran cat")).Success, Equals, false)
+}
+
+func (s *MySuite) TestKeyCompilerClassGetsObject(c *C) {
+	compiler := NewKeyCompiler(tokenKey)
+	compiler.MakeClass("title", func(t tokenT) bool { return t.hasTag("title") })
+	compiler.Finalize()
+
+	re := compiler.MustCompile(". ([:title:]+)")
+	m := re.FullMatch(tokens("The Big Cat"))
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Group(1), Equals, Range{1, 3})
+	c.Check(re.FullMatch(tokens("the big cat")).Success, Equals, false)
+}
+
+func (s *MySuite) TestKeyCompilerBuild(c *C) {
+	compiler := NewKeyCompiler(tokenKey)
+	compiler.MakeClass("title", func(t tokenT) bool { return t.hasTag("title") })
+	compiler.AddIdentity("a", tokenT{word: "a"})
+	compiler.Finalize()
+
+	re := compiler.MustBuild(Seq(IdentityRef("a"), Not(ClassRef("title"))))
+	c.Check(re.FullMatch(tokens("A dog")).Success, Equals, true)
+	c.Check(re.FullMatch(tokens("A Dog")).Success, Equals, false)
+}
+
+func (s *MySuite) TestKeyCompilerUnknownName(c *C) {
+	compiler := NewKeyCompiler(tokenKey)
+	compiler.Finalize()
+
+	_, err := compiler.Compile("[:nope:]")
+	c.Check(err, ErrorMatches, "No such class or identity name 'nope' at pos 1")
+}
+
+func (s *MySuite) TestKeyCompilerFieldGetsObject(c *C) {
+	compiler := NewKeyCompiler(tokenKey)
+	compiler.AddField("word", func(t tokenT) string { return t.word })
+	compiler.Finalize()
+
+	c.Check(compiler.Fields(), DeepEquals, []string{"word"})
+
+	re := compiler.MustCompile("[:word=Dog:]")
+	c.Check(re.FullMatch(tokens("Dog")).Success, Equals, true)
+	c.Check(re.FullMatch(tokens("dog")).Success, Equals, false)
+}
+
+func (s *MySuite) TestKeyCompilerClassFactoryGetsObject(c *C) {
+	compiler := NewKeyCompiler(tokenKey)
+	compiler.AddClassFactory("tag", func(tag string) (func(tokenT) bool, error) {
+		return func(t tokenT) bool { return t.hasTag(tag) }, nil
+	})
+	compiler.Finalize()
+
+	c.Check(compiler.ClassFactories(), DeepEquals, []string{"tag"})
+
+	re := compiler.MustCompile("[:tag(title):]+")
+	c.Check(re.FullMatch(tokens("Big Dog")).Success, Equals, true)
+	c.Check(re.FullMatch(tokens("Big dog")).Success, Equals, false)
+}
+
+func (s *MySuite) TestKeyCompilerChildUsesParentNames(c *C) {
+	compiler := NewKeyCompiler(tokenKey)
+	compiler.AddIdentity("the", tokenT{word: "the"})
+	compiler.Finalize()
+
+	child := compiler.NewChild()
+	child.AddIdentity("dog", tokenT{word: "dog"})
+	child.Finalize()
+	re := child.MustCompile("[:the:] [:dog:]")
+	c.Check(re.FullMatch(tokens("the DOG")).Success, Equals, true)
+
+	// The parent is not changed
+	_, err := compiler.Compile("[:dog:]")
+	c.Check(err, ErrorMatches, "No such class or identity name 'dog' at pos 1")
+}
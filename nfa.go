@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"strings"
+
+	"github.com/gilramir/objregexp/syntax"
 )
 
 // Made by starting with these sources, and modifying:
@@ -16,50 +18,106 @@ import (
 // https://www.oilshell.org/archive/Thompson-1968.pdf
 // https://swtch.com/~rsc/regexp/regexp1.html
 
-// The state needed to convert a stream of tokenT's into a Regexp with
+// The state needed to convert a syntax tree into a Regexp with
 // an nfa in it.
 type nfaFactory[T comparable] struct {
 	compiler *Compiler[T]
 
-	// Stack pointer, and stack, while buildind the NFA stack (regexp)
-	// Literals push these NFA fragments onto the stack.
-	// Operators pop NFA fragments off ot the stack.
-	stp   int
-	stack []fragT[T]
+	// The names in the regex, resolved by the compiler
+	names *nameCacheT[T]
+
+	// The regex being compiled, for reporting errors
+	pattern string
+
+	// How many lookbehinds are we inside of? A lookbehind's NFA
+	// reads the objects backwards, so its concatenations are reversed.
+	lookbehindDepth int
 
 	// how many registers are addressed by this regex
 	numRegisters int
 
 	// Maps regNames to regNums
 	regNameMap map[string]int
+
+	// Are there any backreferences?
+	hasBackrefs bool
 }
 
 func newNfaFactory[T comparable](compiler *Compiler[T]) *nfaFactory[T] {
 	return &nfaFactory[T]{
 		compiler:   compiler,
-		stack:      make([]fragT[T], 0),
+		names:      newNameCache(compiler),
 		regNameMap: make(map[string]int),
 	}
 }
 
-func (s *nfaFactory[T]) stackRepr() string {
-	repr := ""
-	for i := 0; i < s.stp; i++ {
-		repr += fmt.Sprintf("#%d: %s\n", i, s.stack[i].Repr())
+// Create a SyntaxError for the problem at byte offset pos
+func (s *nfaFactory[T]) errorf(code ErrorCode, pos int, f string, args ...any) *SyntaxError {
+	return newSyntaxError(s.pattern, pos, code, f, args...)
+}
+
+// Check that the class and identity names in the tree are in the
+// compiler, and aren't ambiguous.
+func (s *nfaFactory[T]) nameErrors(re *syntax.Regexp) []*SyntaxError {
+	errs := make([]*SyntaxError, 0)
+	switch re.Op {
+	case syntax.OpClass:
+		// Offsets are reported after the '['
+		ref, ambiguous := s.names.resolve(re.Name)
+		if ambiguous != nil {
+			errs = append(errs, s.errorf(ErrAmbiguousName, re.Pos+1,
+				"The name '%s' at pos %d is ambiguous; it is in the imports %s",
+				re.Name, re.Pos+1, strings.Join(ambiguous, ", ")))
+		} else if ref.err != nil {
+			// The argument is after the name's ':'
+			factory, arg, argOffset, _ := syntax.SplitArg(re.Name)
+			pos := re.Pos + strings.IndexByte(s.pattern[re.Pos:], ':') + 1 + argOffset
+			errs = append(errs, s.errorf(ErrInvalidArgument, pos,
+				"Invalid argument '%s' to the class factory '%s' at pos %d: %v",
+				arg, factory, pos, ref.err))
+		} else if ref.kind == 0 {
+			errs = append(errs, s.errorf(ErrUnknownName, re.Pos+1,
+				"No such class or identity name '%s' at pos %d", re.Name, re.Pos+1))
+		}
+	case syntax.OpClassExpr:
+		walkClassNames(re.Class, func(ce *syntax.ClassExpr) {
+			// The error is reported at the first rune of the name,
+			// in the class string, and in the whole regex
+			ref, ambiguous := s.names.resolve(ce.Name)
+			if ambiguous != nil {
+				errs = append(errs, s.errorf(ErrAmbiguousName, ce.Pos+1,
+					"Parsing class string at pos %d: Class :%s: at pos %d is ambiguous; it is in the imports %s",
+					re.Pos+1, ce.Name, ce.Pos-re.Pos, strings.Join(ambiguous, ", ")))
+			} else if ref.err != nil {
+				factory, arg, argOffset, _ := syntax.SplitArg(ce.Name)
+				errs = append(errs, s.errorf(ErrInvalidArgument, ce.Pos+1+argOffset,
+					"Parsing class string at pos %d: Invalid argument '%s' to the class factory '%s' at pos %d: %v",
+					re.Pos+1, arg, factory, ce.Pos-re.Pos+argOffset, ref.err))
+			} else if ref.kind == 0 {
+				errs = append(errs, s.errorf(ErrUnknownName, ce.Pos+1,
+					"Parsing class string at pos %d: Class :%s: at pos %d is unknown",
+					re.Pos+1, ce.Name, ce.Pos-re.Pos))
+			}
+		})
+	}
+	for _, sub := range re.Sub {
+		errs = append(errs, s.nameErrors(sub)...)
 	}
-	return repr
+	return errs
 }
 
 // The type of nfa node
 type nodeType int
 
 const (
-	ntClass    nodeType = iota // matches a Class
-	ntIdentity                 // matches an Identity
-	ntDynClass                 // matches a DynClass
-	ntMeta                     // a meta symbol
-	ntMatch                    // the match state
-	ntSplit                    // a split node
+	ntClass       nodeType = iota // matches a Class
+	ntIdentity                    // matches an Identity
+	ntDynClass                    // matches a DynClass
+	ntIdentitySet                 // matches any of a set of identities
+	ntMeta                        // a meta symbol
+	ntMatch                       // the match state
+	ntSplit                       // a split node
+	ntBackref                     // matches the objects a register captured
 )
 
 type metaType int
@@ -69,8 +127,20 @@ const (
 	mtAny metaType = iota + 1
 	mtAssertBegin
 	mtAssertEnd
+	mtLookahead
+	mtLookbehind
 )
 
+// A zero-width assertion has an NFA of its own, which is tried against
+// the input without consuming any of it.
+type assertionT[T comparable] struct {
+	// the root node of the assertion's NFA
+	nfa *nfaStateT[T]
+
+	// the assertion's own matching state
+	matchstate nfaStateT[T]
+}
+
 // Represents an NFA state plus zero or one or two arrows exiting.
 // Important - once a regex is compiled, nothing in a nfaStateT can change.
 // Otherwise, a single regex cannot be used in multiple concurrent goroutines
@@ -88,15 +158,32 @@ type nfaStateT[T comparable] struct {
 	// if c is ntDynClass
 	dynClass *dynClassT[T]
 
+	// normalizer can be set if c is ntIdentity
+	normalizer *normalizerT[T]
+
+	// iSet is set if c is ntIdentitySet
+	iSet *identitySetT[T]
+
 	// cName is set if c is ntClass or ntIdentity or ntDynClass
+	// or ntIdentitySet
 	cName string
 
-	// negation is valid for either oClass or iObj
+	// negation is valid for oClass, iObj or iSet, or an assertion
 	negation bool
 
 	// meta is set if c is ntMeta
 	meta metaType
 
+	// assertion is set if meta is mtLookahead or mtLookbehind
+	assertion *assertionT[T]
+
+	// regNum is set if c is ntBackref
+	regNum int
+
+	// lazy is set if c is ntSplit, and out1 is to be preferred
+	// over out. This is how lazy globs prefer fewer repetitions.
+	lazy bool
+
 	out, out1 *nfaStateT[T]
 
 	// At this node, which registers start collecting
@@ -120,7 +207,13 @@ func (s *nfaStateT[T]) Repr0() string {
 	case ntMatch:
 		label = "MATCH"
 	case ntSplit:
-		label = "SPLIT"
+		if s.lazy {
+			label = "LAZY SPLIT"
+		} else {
+			label = "SPLIT"
+		}
+	case ntBackref:
+		label = fmt.Sprintf("\\%d", s.regNum)
 	case ntMeta:
 		switch s.meta {
 		case mtAny:
@@ -129,6 +222,18 @@ func (s *nfaStateT[T]) Repr0() string {
 			label = "^"
 		case mtAssertEnd:
 			label = "$"
+		case mtLookahead:
+			if s.negation {
+				label = "(?!)"
+			} else {
+				label = "(?=)"
+			}
+		case mtLookbehind:
+			if s.negation {
+				label = "(?<!)"
+			} else {
+				label = "(?<=)"
+			}
 		default:
 			label = "MT?"
 		}
@@ -136,6 +241,13 @@ func (s *nfaStateT[T]) Repr0() string {
 	case ntDynClass:
 		label = s.cName
 
+	case ntIdentitySet:
+		if s.negation {
+			label = "!(" + s.cName + ")"
+		} else {
+			label = s.cName
+		}
+
 	case ntClass:
 		if s.negation {
 			label = "!" + s.oClass.Name
@@ -158,7 +270,13 @@ func (s *nfaStateT[T]) Repr0Dot() string {
 	case ntMatch:
 		label = "MATCH"
 	case ntSplit:
-		label = "SPLIT"
+		if s.lazy {
+			label = "LAZY SPLIT"
+		} else {
+			label = "SPLIT"
+		}
+	case ntBackref:
+		label = fmt.Sprintf("\\%d", s.regNum)
 	case ntMeta:
 		switch s.meta {
 		case mtAny:
@@ -167,12 +285,30 @@ func (s *nfaStateT[T]) Repr0Dot() string {
 			label = "^"
 		case mtAssertEnd:
 			label = "$"
+		case mtLookahead:
+			if s.negation {
+				label = "(?!)"
+			} else {
+				label = "(?=)"
+			}
+		case mtLookbehind:
+			if s.negation {
+				label = "(?<!)"
+			} else {
+				label = "(?<=)"
+			}
 		default:
 			label = "MT?"
 		}
 
 	case ntDynClass:
 		label = s.cName
+	case ntIdentitySet:
+		if s.negation {
+			label = "!(" + s.cName + ")"
+		} else {
+			label = s.cName
+		}
 	case ntClass:
 		if s.negation {
 			label = "!" + s.oClass.Name
@@ -265,210 +401,449 @@ func (s *fragT[T]) Repr() string {
 	return fmt.Sprintf("start: %s out: %v er: %v", s.start.Repr(), out_repr, s.endsRegisters)
 }
 
+// The states reachable from the start of the fragment. Until a fragment
+// is patched into a larger one, these are exactly the states that belong
+// to it, as its outs are still unconnected.
+func (s *fragT[T]) states() []*nfaStateT[T] {
+	saw := make(map[*nfaStateT[T]]bool)
+	states := make([]*nfaStateT[T], 0)
+	pending := []*nfaStateT[T]{s.start}
+	for len(pending) > 0 {
+		ns := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if ns == nil || saw[ns] {
+			continue
+		}
+		saw[ns] = true
+		states = append(states, ns)
+		pending = append(pending, ns.out, ns.out1)
+	}
+	return states
+}
+
+func (s *fragT[T]) numStates() int {
+	return len(s.states())
+}
+
+// Make a deep copy of an unpatched fragment, so it can be used
+// more than once in the NFA.
+func (s *fragT[T]) clone() fragT[T] {
+	states := s.states()
+	stateMap := make(map[*nfaStateT[T]]*nfaStateT[T], len(states))
+	outMap := make(map[**nfaStateT[T]]**nfaStateT[T], len(states)*2)
+	for _, ns := range states {
+		c := *ns
+		// Copy the slices, so that patching one copy
+		// doesn't modify the other.
+		c.startsRegisters = append([]int(nil), ns.startsRegisters...)
+		c.endsRegisters = append([]int(nil), ns.endsRegisters...)
+		stateMap[ns] = &c
+		outMap[&ns.out] = &c.out
+		outMap[&ns.out1] = &c.out1
+	}
+	for _, c := range stateMap {
+		if c.out != nil {
+			c.out = stateMap[c.out]
+		}
+		if c.out1 != nil {
+			c.out1 = stateMap[c.out1]
+		}
+	}
+	out := make([]**nfaStateT[T], len(s.out))
+	for i, o := range s.out {
+		out[i] = outMap[o]
+	}
+	return fragT[T]{
+		start:         stateMap[s.start],
+		out:           out,
+		endsRegisters: append([]int(nil), s.endsRegisters...),
+	}
+}
+
 /* Patch the list of states at out to point to s. */
 func (s *nfaFactory[T]) patch(f fragT[T], out []**nfaStateT[T], ns *nfaStateT[T]) {
+	if len(f.endsRegisters) > 0 {
+		// The registers end on a split node of their own, which
+		// leads only to ns. If they were placed on ns itself, a glob
+		// looping back to ns would end them again on each repetition.
+		ns = &nfaStateT[T]{c: ntSplit, out: ns,
+			endsRegisters: append([]int(nil), f.endsRegisters...)}
+	}
 	for _, p := range out {
-		if len(f.endsRegisters) > 0 {
-			ns.endsRegisters = append(ns.endsRegisters, f.endsRegisters...)
-		}
 		*p = ns
 	}
 }
 
-func (s *nfaFactory[T]) ensure_stack_space() {
-	if len(s.stack) <= s.stp {
-		extra := s.stp - len(s.stack) + 1
-		s.stack = append(s.stack, make([]fragT[T], extra)...)
+// Place the endsRegisters of e on a node of their own, which e's outs
+// lead to, so that e can be joined with fragments that don't end them
+func (s *nfaFactory[T]) sealFrag(e fragT[T]) fragT[T] {
+	if len(e.endsRegisters) == 0 {
+		return e
 	}
+	ns := &nfaStateT[T]{c: ntSplit,
+		endsRegisters: append([]int(nil), e.endsRegisters...)}
+	for _, p := range e.out {
+		*p = ns
+	}
+	return fragT[T]{e.start, []**nfaStateT[T]{&ns.out}, []int{}}
+}
+
+// e1 followed by e2
+func (s *nfaFactory[T]) concatFrags(e1, e2 fragT[T]) fragT[T] {
+	s.patch(e1, e1.out, e2.start)
+	return fragT[T]{e1.start, e2.out, e2.endsRegisters}
+}
+
+// 0 or 1 of e
+func (s *nfaFactory[T]) questionFrag(e fragT[T], lazy bool) fragT[T] {
+	ns := nfaStateT[T]{c: ntSplit, out: e.start, lazy: lazy}
+	// The endsRegisters are carried along, so that they are placed
+	// on whatever follows, whether e was matched or skipped.
+	return fragT[T]{&ns, append(e.out, &ns.out1), e.endsRegisters}
+}
+
+// 0 or more of e
+func (s *nfaFactory[T]) starFrag(e fragT[T], lazy bool) fragT[T] {
+	ns := nfaStateT[T]{c: ntSplit, out: e.start, lazy: lazy}
+	s.patch(e, e.out, &ns)
+	return fragT[T]{&ns, []**nfaStateT[T]{&ns.out1}, []int{}}
+}
+
+// 1 or more of e
+func (s *nfaFactory[T]) plusFrag(e fragT[T], lazy bool) fragT[T] {
+	ns := nfaStateT[T]{c: ntSplit, out: e.start, lazy: lazy}
+	s.patch(e, e.out, &ns)
+	return fragT[T]{e.start, []**nfaStateT[T]{&ns.out1}, []int{}}
 }
 
-func (s *nfaFactory[T]) token2nfa(tnum int, token tokenT) error {
+// A fragment that matches nothing, and consumes no input
+func (s *nfaFactory[T]) emptyFrag() fragT[T] {
+	ns := nfaStateT[T]{c: ntSplit}
+	return fragT[T]{&ns, []**nfaStateT[T]{&ns.out}, []int{}}
+}
+
+// The maximum number of NFA states that a single {m,n} repetition
+// can expand into.
+const maxRepeatStates = 100000
+
+// Expand e{m,n} into copies of e. x{2,4} becomes x x (x x?)?,
+// and x{2,} becomes x x+. Every copy keeps the register numbers of the
+// original, so a repeated group covers all of its repetitions, just as
+// it does for "*" and "+".
+func (s *nfaFactory[T]) repeatFrag(e fragT[T], re *syntax.Regexp) (fragT[T], error) {
+	min, max, lazy := re.Min, re.Max, re.Lazy
+
+	if max == 0 {
+		return s.emptyFrag(), nil
+	}
+
+	// How many copies of e are needed?
+	numCopies := max
+	if max == -1 {
+		numCopies = min
+		if numCopies == 0 {
+			numCopies = 1
+		}
+	}
+	if numCopies*e.numStates() > maxRepeatStates {
+		return fragT[T]{}, s.errorf(ErrInvalidRepetition, re.Pos,
+			"The repetition at pos %d expands to more than %d states",
+			re.Pos, maxRepeatStates)
+	}
 
-	dlog.Printf("---------------------")
-	dlog.Printf("token2nfa: #%d %s", tnum, token.Repr())
-	dlog.Printf("stack:\n%s", s.stackRepr())
-	switch token.ttype {
+	copies := make([]fragT[T], numCopies)
+	copies[0] = e
+	for i := 1; i < numCopies; i++ {
+		copies[i] = e.clone()
+	}
 
-	case tClass: // could be a Class or an identity
-		ctype, has := s.compiler.namespace[token.name]
-		if !has {
-			return fmt.Errorf("No such class or identity name '%s' at pos %d", token.name, token.pos)
+	var optional *fragT[T]
+	if max == -1 {
+		if min == 0 {
+			return s.starFrag(e, lazy), nil
 		}
-		var ns nfaStateT[T]
-		switch ctype {
-		case ccClass:
-			class, has := s.compiler.classMap[token.name]
-			if !has {
-				panic(fmt.Sprintf("Should have found class '%s' at pos %d", token.name, token.pos))
+		copies[min-1] = s.plusFrag(copies[min-1], lazy)
+	} else {
+		// Nest the optional copies from the inside out
+		for i := max - 1; i >= min; i-- {
+			f := copies[i]
+			if optional != nil {
+				f = s.concatFrags(f, *optional)
 			}
-			ns = nfaStateT[T]{c: ntClass, oClass: class, cName: token.name, negation: token.negation,
-				out: nil, out1: nil}
+			f = s.questionFrag(f, lazy)
+			optional = &f
+		}
+	}
+
+	var result *fragT[T]
+	for i := 0; i < min; i++ {
+		if result == nil {
+			result = &copies[i]
+		} else {
+			f := s.concatFrags(*result, copies[i])
+			result = &f
+		}
+	}
+	if optional != nil {
+		if result == nil {
+			result = optional
+		} else {
+			f := s.concatFrags(*result, *optional)
+			result = &f
+		}
+	}
+	return *result, nil
+}
+
+// A fragment with a single node, whose out needs a connection
+func singleFrag[T comparable](ns *nfaStateT[T]) fragT[T] {
+	return fragT[T]{ns, []**nfaStateT[T]{&ns.out}, []int{}}
+}
+
+func (s *nfaFactory[T]) node2nfa(re *syntax.Regexp) (fragT[T], error) {
+
+	dlog.Printf("node2nfa: %s at pos %d", re.Op, re.Pos)
+	switch re.Op {
+
+	case syntax.OpClass: // could be a Class or an identity
+		ref, _ := s.names.resolve(re.Name)
+		switch ref.kind {
+		case ccClass, ccIdentitySet:
+			return singleFrag(&nfaStateT[T]{c: ntClass, oClass: ref.class,
+				cName: re.Name, negation: re.Negate}), nil
 		case ccIdentity:
-			obj, has := s.compiler.identityObj[token.name]
-			if !has {
-				panic(fmt.Sprintf("Should have found identity '%s' at pos %d", token.name, token.pos))
-			}
-			ns = nfaStateT[T]{c: ntIdentity, iObj: obj, cName: token.name, negation: token.negation,
-				out: nil, out1: nil}
+			return singleFrag(&nfaStateT[T]{c: ntIdentity, iObj: ref.identity,
+				normalizer: ref.normalizer, cName: re.Name, negation: re.Negate}), nil
 		default:
-			panic(fmt.Sprintf("Unexpected ctype %v for token %s", ctype, token.name))
+			panic(fmt.Sprintf("Unexpected name %s at pos %d", re.Name, re.Pos))
+		}
+
+	case syntax.OpClassExpr:
+		// An alternation of identities, like "[:a:] | [:b:]", is
+		// simplified into "[:a: || :b:]", which becomes one lookup
+		if set, negation := identitySetExpr(re.Class, s.names); set != nil {
+			return singleFrag(&nfaStateT[T]{c: ntIdentitySet, iSet: set,
+				cName: set.names, negation: negation}), nil
+		}
+		dynClass := newDynClassFromExpr(re.Class, s.names)
+		return singleFrag(&nfaStateT[T]{c: ntDynClass, dynClass: dynClass,
+			cName: re.Class.String()}), nil
+
+	case syntax.OpAnyObject:
+		return singleFrag(&nfaStateT[T]{c: ntMeta, meta: mtAny}), nil
+
+	case syntax.OpBeginText:
+		return singleFrag(&nfaStateT[T]{c: ntMeta, meta: mtAssertBegin}), nil
+
+	case syntax.OpEndText:
+		return singleFrag(&nfaStateT[T]{c: ntMeta, meta: mtAssertEnd}), nil
+
+	case syntax.OpConcat:
+		frags, err := s.subs2nfa(re)
+		if err != nil {
+			return fragT[T]{}, err
+		}
+		if len(frags) == 0 {
+			return s.emptyFrag(), nil
+		}
+		if s.lookbehindDepth > 0 {
+			// A lookbehind reads the objects backwards
+			for i, j := 0, len(frags)-1; i < j; i, j = i+1, j-1 {
+				frags[i], frags[j] = frags[j], frags[i]
+			}
+		}
+		e := frags[0]
+		for _, f := range frags[1:] {
+			e = s.concatFrags(e, f)
+		}
+		return e, nil
+
+	case syntax.OpAlternate: // |
+		frags, err := s.subs2nfa(re)
+		if err != nil {
+			return fragT[T]{}, err
+		}
+		// Each choice is preferred over the ones after it. A
+		// choice's registers end when it does, not after the others.
+		e := s.sealFrag(frags[len(frags)-1])
+		for i := len(frags) - 2; i >= 0; i-- {
+			f := s.sealFrag(frags[i])
+			ns := nfaStateT[T]{c: ntSplit, out: f.start, out1: e.start}
+			e = fragT[T]{&ns, append(f.out, e.out...), []int{}}
+		}
+		return e, nil
+
+	case syntax.OpQuest, syntax.OpStar, syntax.OpPlus, syntax.OpRepeat:
+		e, err := s.node2nfa(re.Sub[0])
+		if err != nil {
+			return fragT[T]{}, err
+		}
+		switch re.Op {
+		case syntax.OpQuest: // 0 or 1
+			return s.questionFrag(e, re.Lazy), nil
+		case syntax.OpStar: // 0 or more
+			return s.starFrag(e, re.Lazy), nil
+		case syntax.OpPlus: // 1 or more
+			return s.plusFrag(e, re.Lazy), nil
+		default: // {m}, {m,}, {m,n}
+			return s.repeatFrag(e, re)
+		}
+
+	case syntax.OpLookahead, syntax.OpLookbehind:
+		// The fragment becomes the NFA of the assertion, and a
+		// single node, which tests the assertion, takes its place.
+		// A lookbehind's fragment is built with its concatenations
+		// reversed, so its NFA reads the objects backwards.
+		meta := mtLookahead
+		if re.Op == syntax.OpLookbehind {
+			meta = mtLookbehind
+			s.lookbehindDepth++
 		}
-		s.stack[s.stp] = fragT[T]{&ns, []**nfaStateT[T]{&ns.out}, []int{}}
-		s.stp++
-		s.ensure_stack_space()
+		e, err := s.node2nfa(re.Sub[0])
+		if re.Op == syntax.OpLookbehind {
+			s.lookbehindDepth--
+		}
+		if err != nil {
+			return fragT[T]{}, err
+		}
+		a := &assertionT[T]{}
+		a.matchstate.c = ntMatch
+		s.patch(e, e.out, &a.matchstate)
+		a.nfa = e.start
+
+		return singleFrag(&nfaStateT[T]{c: ntMeta, meta: meta, negation: re.Negate,
+			assertion: a}), nil
 
-	case tDynClass:
-		dynClass, err := newDynClassT[T](token.name, s.compiler)
+	case syntax.OpBackref:
+		s.hasBackrefs = true
+		return singleFrag(&nfaStateT[T]{c: ntBackref, regNum: re.Cap}), nil
+
+	case syntax.OpCapture:
+		e, err := s.node2nfa(re.Sub[0])
 		if err != nil {
-			return fmt.Errorf("Parsing class string at pos %d: %s",
-				token.pos, err)
-		}
-
-		ns := nfaStateT[T]{c: ntDynClass, dynClass: dynClass, cName: token.name,
-			out: nil, out1: nil}
-		s.stack[s.stp] = fragT[T]{&ns, []**nfaStateT[T]{&ns.out}, []int{}}
-		s.stp++
-		s.ensure_stack_space()
-
-	case tConcat:
-		s.stp--
-		e2 := s.stack[s.stp]
-		s.stp--
-		e1 := s.stack[s.stp]
-		// concatenate
-		s.patch(e1, e1.out, e2.start)
-		s.stack[s.stp] = fragT[T]{e1.start, e2.out, e2.endsRegisters}
-		s.stp++
-		// No need to call ensure_stack_space here; we popped 2
-		// and added 1
-
-	case tAlternate: // |
-		s.stp--
-		e2 := s.stack[s.stp]
-		s.stp--
-		e1 := s.stack[s.stp]
-		ns := nfaStateT[T]{c: ntSplit, out: e1.start, out1: e2.start}
-		s.stack[s.stp] = fragT[T]{&ns, append(e1.out, e2.out...), []int{}}
-		s.stp++
-		// No need to call ensure_stack_space here; we popped 2
-		// and added 1
-
-	case tGlobQuestion: // 0 or 1
-		s.stp--
-		e := s.stack[s.stp]
-		ns := nfaStateT[T]{c: ntSplit, out: e.start}
-		s.stack[s.stp] = fragT[T]{&ns, append(e.out, &ns.out1), []int{}}
-
-		if len(e.endsRegisters) > 0 {
-			s.stack[s.stp].endsRegisters = e.endsRegisters
-			e.endsRegisters = []int{}
-		}
-
-		s.stp++
-		// No need to call ensure_stack_space here; we popped 1
-		// and added 1
-
-	case tGlobStar: // 0 or more
-		s.stp--
-		e := s.stack[s.stp]
-		ns := nfaStateT[T]{c: ntSplit, out: e.start}
-		s.patch(e, e.out, &ns)
-		s.stack[s.stp] = fragT[T]{&ns, []**nfaStateT[T]{&ns.out1}, []int{}}
-		s.stp++
-		// No need to call ensure_stack_space here; we popped 1
-		// and added 1
-
-	case tGlobPlus: // 1 or more
-		s.stp--
-		e := s.stack[s.stp]
-		ns := nfaStateT[T]{c: ntSplit, out: e.start}
-		s.patch(e, e.out, &ns)
-		s.stack[s.stp] = fragT[T]{e.start, []**nfaStateT[T]{&ns.out1}, []int{}}
-		s.stp++
-		// No need to call ensure_stack_space here; we popped 1
-		// and added 1
-
-	case tAny:
-		ns := nfaStateT[T]{c: ntMeta, meta: mtAny, out: nil, out1: nil}
-		s.stack[s.stp] = fragT[T]{&ns, []**nfaStateT[T]{&ns.out}, []int{}}
-		s.stp++
-		s.ensure_stack_space()
-
-	case tAssertBegin:
-		ns := nfaStateT[T]{c: ntMeta, meta: mtAssertBegin, out: nil, out1: nil}
-		s.stack[s.stp] = fragT[T]{&ns, []**nfaStateT[T]{&ns.out}, []int{}}
-		s.stp++
-		s.ensure_stack_space()
-
-	case tAssertEnd:
-		ns := nfaStateT[T]{c: ntMeta, meta: mtAssertEnd, out: nil, out1: nil}
-		s.stack[s.stp] = fragT[T]{&ns, []**nfaStateT[T]{&ns.out}, []int{}}
-		s.stp++
-		s.ensure_stack_space()
-
-	case tEndRegister:
+			return fragT[T]{}, err
+		}
 		// An EndRegister cannot exist on an ntSplit node. It is pushed
 		// down onto the final leavs of the ntSplit node/tree (ending up
 		// on the er slices)
-		dlog.Printf("tEndRegister reg#%d name %s", token.regNum, token.regName)
+		dlog.Printf("capture reg#%d name %s", re.Cap, re.Name)
 
-		ns := s.stack[s.stp-1].start
-		ns.startsRegisters = append(ns.startsRegisters, token.regNum)
-		s.stack[s.stp-1].endsRegisters = append(s.stack[s.stp-1].endsRegisters, token.regNum)
+		e.start.startsRegisters = append(e.start.startsRegisters, re.Cap)
+		e.endsRegisters = append(e.endsRegisters, re.Cap)
 
-		if token.regNum > s.numRegisters {
-			s.numRegisters = token.regNum
+		if re.Cap > s.numRegisters {
+			s.numRegisters = re.Cap
 		}
-		if token.regName != "" {
-			if registeredNum, has := s.regNameMap[token.regName]; has {
-				return fmt.Errorf("Capture group name '%s' is for registers %d and %d", token.regName,
-					registeredNum, token.regNum)
-			}
-			s.regNameMap[token.regName] = token.regNum
+		// The parser has already reported names used twice
+		if _, has := s.regNameMap[re.Name]; re.Name != "" && !has {
+			s.regNameMap[re.Name] = re.Cap
 		}
+		return e, nil
 
 	default:
-		e := fmt.Sprintf("%s not handled\n", string(token.ttype))
-		panic(e)
+		panic(fmt.Sprintf("%s not handled", re.Op))
+	}
+}
+
+// Make the fragments of the subs of re
+func (s *nfaFactory[T]) subs2nfa(re *syntax.Regexp) ([]fragT[T], error) {
+	frags := make([]fragT[T], len(re.Sub))
+	for i, sub := range re.Sub {
+		var err error
+		frags[i], err = s.node2nfa(sub)
+		if err != nil {
+			return nil, err
+		}
 	}
-	dlog.Printf("now the stack is:\n%s", s.stackRepr())
-	return nil
+	return frags, nil
 }
 
 func (s *nfaFactory[T]) compile(text string) (*Regexp[T], error) {
 
-	tokens, err := parseRegex(text)
+	s.pattern = text
+	tree, errs := syntax.ParseAll(text)
+	if tree != nil {
+		errs = append(errs, s.nameErrors(tree)...)
+	}
+	if len(errs) > 0 {
+		return nil, syntax.JoinErrors(errs)
+	}
+	re, err := s.compileTree(tree)
 	if err != nil {
-		return nil, fmt.Errorf("Parsing objregexp: %w", err)
+		return nil, err
 	}
+	re.source = text
+	return re, nil
+}
 
-	printTokens(tokens)
+// Make a Regexp from a syntax tree whose names have been checked.
+// The tree is simplified first.
+func (s *nfaFactory[T]) compileTree(tree *syntax.Regexp) (*Regexp[T], error) {
+
+	var canonical string
+	var names []string
+	if tree != nil {
+		canonical = tree.String()
+		names = treeNames(tree, names, make(map[string]bool))
+		tree = tree.Simplify()
+		dlog.Printf("simplified: %s", tree)
+	}
+	re, err := s.treeRegexp(tree, canonical)
+	if err != nil {
+		return nil, err
+	}
+	re.names = names
+	return re, nil
+}
 
-	// stp is where a new item will be placed in the stack.
-	// nfastack must always have allocated space for an item at index 'stp'
-	s.stp = 0
-	s.stack = make([]fragT[T], 1)
+// Append the class and identity names in the tree to names, in the
+// order that they first appear. seen holds the names already appended.
+func treeNames(re *syntax.Regexp, names []string, seen map[string]bool) []string {
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	switch re.Op {
+	case syntax.OpClass:
+		add(re.Name)
+	case syntax.OpClassExpr:
+		walkClassNames(re.Class, func(ce *syntax.ClassExpr) {
+			add(ce.Name)
+		})
+	}
+	for _, sub := range re.Sub {
+		names = treeNames(sub, names, seen)
+	}
+	return names
+}
+
+// Make a Regexp from the syntax tree, as it is, with the canonical form
+// of the regex that it came from
+func (s *nfaFactory[T]) treeRegexp(tree *syntax.Regexp, canonical string) (*Regexp[T], error) {
 
-	for i, token := range tokens {
-		err = s.token2nfa(i, token)
+	// An empty regex matches without consuming anything
+	e := s.emptyFrag()
+	if tree != nil {
+		var err error
+		e, err = s.node2nfa(tree)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	// After pushing and popping the stack, it should be empty
-	s.stp--
-	e := s.stack[s.stp]
-	if s.stp != 0 {
-		panic(fmt.Sprintf("compile failed: stp=%d e=%s", s.stp,
-			e.Repr()))
-	}
 	re := &Regexp[T]{
 		numRegisters: s.numRegisters,
 		regNameMap:   s.regNameMap,
+		hasBackrefs:  s.hasBackrefs,
+		normalizer:   s.compiler.normalizer,
 	}
 	re.matchstate.c = ntMatch
 
+	re.canonical = canonical
+	re.source = canonical
+
 	s.patch(e, e.out, &re.matchstate)
 	re.nfa = e.start
 	re.initialObj = re.mustStartWith()
@@ -3,7 +3,9 @@ package objregexp
 // Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
 
 import (
-	. "gopkg.in/check.v1"
+	"strings"
+
+	. "github.com/gilramir/objregexp/internal/check"
 )
 
 // Found this during development of paasaathai module
@@ -590,3 +592,114 @@ func (s *MySuite) TestNfa13(c *C) {
 	c.Assert(reg2.Start, Equals, 0)
 	c.Assert(reg2.End, Equals, 1)
 }
+
+// A capture group which is a whole alternate choice ends where the
+// choice does
+func (s *MySuite) TestNfa14(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	re := compiler.MustCompile("([:vowel:]+) | ([:digit:])")
+
+	m := re.FullMatch([]rune("5"))
+	c.Assert(m.Success, Equals, true)
+	c.Check(m.HasGroup(1), Equals, false)
+	c.Check(m.Group(2), Equals, Range{0, 1})
+
+	m = re.FullMatch([]rune("ae"))
+	c.Assert(m.Success, Equals, true)
+	c.Check(m.Group(1), Equals, Range{0, 2})
+	c.Check(m.HasGroup(2), Equals, false)
+
+	// Followed by more of the regex
+	re = compiler.MustCompile("(?:([:vowel:]) | ([:digit:])) [:digit:]")
+	m = re.FullMatch([]rune("a1"))
+	c.Assert(m.Success, Equals, true)
+	c.Check(m.Group(1), Equals, Range{0, 1})
+	c.Check(m.HasGroup(2), Equals, false)
+}
+
+// An alternation of identities becomes one node with a map
+func (s *MySuite) TestNfaIdentitySet01(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(DigitClass)
+	names := make([]string, 0, len(lowers))
+	for _, r := range lowers {
+		name := "id " + string(r)
+		compiler.AddIdentity(name, r)
+		names = append(names, "[:"+name+":]")
+	}
+	compiler.Finalize()
+
+	re := compiler.MustCompile("(" + strings.Join(names, " | ") + ")+ [:digit:]")
+	c.Check(re.nfa.c, Equals, ntIdentitySet)
+	c.Check(re.nfa.iSet.set, HasLen, 26)
+	c.Check(re.nfa.startsRegisters, DeepEquals, []int{1})
+	m := re.Search([]rune("ABcde5"))
+	c.Check(m.Range, Equals, Range{2, 6})
+	// A repeated group covers all of its repetitions
+	c.Check(m.Group(1), Equals, Range{2, 5})
+
+	re = compiler.MustCompile("[!(:id a: || :id b:)]")
+	c.Check(re.nfa.c, Equals, ntIdentitySet)
+	c.Check(re.nfa.negation, Equals, true)
+	c.Check(re.Search([]rune("abc")).Range, Equals, Range{2, 3})
+
+	// The identities in a mixed || share one lookup
+	re = compiler.MustCompile("[:id a:] | [:digit:] | [:id z:]")
+	c.Assert(re.nfa.c, Equals, ntDynClass)
+	ops := re.nfa.dynClass.ops
+	c.Check(ops[0].opType, Equals, dcopTypeT(dcIdentitySet))
+	c.Check(ops[0].cName, Equals, ":id a: || :id z:")
+	c.Check(ops[2].opType, Equals, dcopTypeT(dcClass))
+	for _, r := range "az5" {
+		c.Check(re.FullMatch([]rune{r}).Success, Equals, true)
+	}
+	c.Check(re.FullMatch([]rune("b")).Success, Equals, false)
+
+	// Alternate choices with their own captures are left alone
+	re = compiler.MustCompile("([:id a:]) | ([:id b:])")
+	c.Check(re.nfa.c, Equals, ntSplit)
+	m = re.FullMatch([]rune("b"))
+	c.Check(m.Success, Equals, true)
+	c.Check(m.HasGroup(1), Equals, false)
+	c.Check(m.Group(2), Equals, Range{0, 1})
+}
+
+// With objects of an interface type, which might not be hashable, the
+// identities are tested one at a time, instead of with a map
+func (s *MySuite) TestNfaIdentitySet02(c *C) {
+	compiler := NewCompiler[any]()
+	c.Check(compiler.hashable, Equals, false)
+	c.Check(NewCompiler[rune]().hashable, Equals, true)
+	compiler.AddIdentity("one", 1)
+	compiler.AddIdentity("two", 2)
+	compiler.Finalize()
+
+	re := compiler.MustCompile("[:one:] | [:two:]")
+	c.Check(re.nfa.c, Equals, ntDynClass)
+	c.Check(re.FullMatch([]any{2}).Success, Equals, true)
+	c.Check(re.FullMatch([]any{[]int{1}}).Success, Equals, false)
+	c.Check(re.Search([]any{[]int{1}, map[int]int{}, 1}).Range, Equals, Range{2, 3})
+
+	re = compiler.MustCompile("[!(:one: || :two:)]")
+	c.Check(re.FullMatch([]any{[]int{1}}).Success, Equals, true)
+
+	// So are objects which have an interface in them
+	type boxT struct{ v any }
+	boxCompiler := NewCompiler[boxT]()
+	c.Check(boxCompiler.hashable, Equals, false)
+	boxCompiler.AddIdentity("one", boxT{1})
+	boxCompiler.AddIdentity("two", boxT{2})
+	boxCompiler.Finalize()
+	c.Check(boxCompiler.NewChild().hashable, Equals, false)
+
+	boxRe := boxCompiler.MustCompile("[:one:] | [:two:]")
+	c.Check(boxRe.nfa.c, Equals, ntDynClass)
+	c.Check(boxRe.FullMatch([]boxT{{[]int{1}}}).Success, Equals, false)
+	c.Check(boxRe.FullMatch([]boxT{{2}}).Success, Equals, true)
+}
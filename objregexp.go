@@ -6,6 +6,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gilramir/objregexp/syntax"
 )
 
 // The debug logger for this module. By default, output is discarded.
@@ -15,9 +21,11 @@ func init() {
 	dlog = log.New(ioutil.Discard, "[DEBUG] ", log.Ldate|log.Lmicroseconds|log.Lshortfile)
 }
 
-// Change the debug logger object in this module
+// Change the debug logger object in this module, including the
+// syntax package
 func SetDebugLogger(logger *log.Logger) {
 	dlog = logger
+	syntax.SetDebugLogger(logger)
 }
 
 // Returns the output of the current debug logger in this module.
@@ -33,6 +41,94 @@ type Compiler[T comparable] struct {
 	namespace   map[string]ccType
 	classMap    map[string]*Class[T]
 	identityObj map[string]T
+
+	// The field accessors, which make the "field=value" classes
+	fields map[string]func(T) string
+
+	// The class factories, which make the "factory(arg)" classes
+	factories map[string]func(string) (func(T) bool, error)
+
+	// The Compiler that this one was made from by NewChild, if any.
+	// The names that aren't in this one's maps are looked up there.
+	parent *Compiler[T]
+
+	// The Compilers whose names were imported by Import, in order
+	imports []importT[T]
+
+	// Maps identities, and the objects compared with them, to the
+	// keys they are compared by. nil if they are compared as they are.
+	normalizer *normalizerT[T]
+
+	// Whether every T can be looked up in a map; see alwaysHashable.
+	// It only depends on T, but reflect is slow, so it is found once.
+	hashable bool
+}
+
+// A function which maps an object to the key it is compared by. It
+// is kept behind a pointer, so that the identities which share one
+// can be found, as functions can't be compared.
+type normalizerT[T comparable] struct {
+	f func(T) T
+}
+
+// Returns the key of the object, which is the object itself if
+// there is no normalizer
+func (s *normalizerT[T]) apply(o T) T {
+	if s == nil {
+		return o
+	}
+	return s.f(o)
+}
+
+// A Compiler whose names are available under a prefix
+type importT[T comparable] struct {
+	prefix   string
+	compiler *Compiler[T]
+}
+
+// The kind of thing that a name refers to
+type NameKind int
+
+const (
+	KindClass       NameKind = ccClass
+	KindIdentity    NameKind = ccIdentity
+	KindIdentitySet NameKind = ccIdentitySet
+)
+
+func (s NameKind) String() string {
+	switch s {
+	case KindClass:
+		return "class"
+	case KindIdentity:
+		return "identity"
+	case KindIdentitySet:
+		return "identity set"
+	default:
+		return "unknown"
+	}
+}
+
+// A name which can be used in a regex, and what it refers to
+type NameInfo struct {
+	Name string
+	Kind NameKind
+}
+
+// What a name refers to
+type nameRefT[T comparable] struct {
+	// ccClass, ccIdentity or ccIdentitySet, or 0 if the name wasn't
+	// found. An identity set has a class which tests the set.
+	kind     ccType
+	class    *Class[T]
+	identity T
+
+	// For an identity, the normalizer of its Compiler. The identity
+	// is already normalized; the objects compared with it must be, too.
+	normalizer *normalizerT[T]
+
+	// If the name passes an argument to a class factory which rejected
+	// it, the factory's error. kind is 0.
+	err error
 }
 
 type ccType int
@@ -40,6 +136,7 @@ type ccType int
 const (
 	ccClass = iota + 1
 	ccIdentity
+	ccIdentitySet
 )
 
 // Instantiates and initializes a new Compiler.
@@ -57,6 +154,278 @@ func (s *Compiler[T]) Initialize() {
 	s.namespace = make(map[string]ccType)
 	s.classMap = make(map[string]*Class[T])
 	s.identityObj = make(map[string]T)
+	s.fields = make(map[string]func(T) string)
+	s.factories = make(map[string]func(string) (func(T) bool, error))
+	s.hashable = alwaysHashable[T]()
+}
+
+// Creates a new Compiler which inherits all of the classes and
+// identities of this one, which must be finalized. The parent's
+// tables are shared, not copied. The child can add more classes and
+// identities, including ones with the same names as the parent's,
+// which override them in the child. The child must be finalized on
+// its own before it can compile regexes. The parent is not changed.
+func (s *Compiler[T]) NewChild() *Compiler[T] {
+	s.assertFinalized()
+	child := NewCompiler[T]()
+	child.parent = s
+	child.normalizer = s.normalizer
+	return child
+}
+
+// Sets a function which maps an object to the key that identities are
+// compared by. Identities, including the ones in identity sets, and the
+// input objects that are tested against them, are compared by their
+// keys, so, for example, a normalizer which returns the lower-case form
+// of a word makes the identities match without regard to case.
+// Backreferences compare their objects by their keys, too. Classes are
+// given the input objects as they are.
+//
+// It must be called before any identities or identity sets are
+// added. A child Compiler uses its parent's normalizer, and can't have
+// its own. The identities of an imported Compiler are compared by that
+// Compiler's normalizer.
+func (s *Compiler[T]) SetNormalizer(normalize func(T) T) {
+	s.assertNotFinalized()
+	if s.parent != nil {
+		panic("A child objregexp.Compiler uses its parent's normalizer")
+	}
+	for _, kind := range s.namespace {
+		if kind == ccIdentity || kind == ccIdentitySet {
+			panic("SetNormalizer must be called before identities are added")
+		}
+	}
+	s.normalizer = &normalizerT[T]{f: normalize}
+}
+
+// Makes the classes and identities of another Compiler, which must be
+// finalized, available in this one as "prefix.name". A name which isn't
+// registered in this Compiler can also be used without its prefix, if
+// only one import has it. The other Compiler's tables are shared, not
+// copied. Panics if the prefix can't be used; see TryImport.
+func (s *Compiler[T]) Import(prefix string, other *Compiler[T]) {
+	if err := s.TryImport(prefix, other); err != nil {
+		panic(err.Error())
+	}
+}
+
+// Like Import, but returns a *NameError instead of panicking, if this
+// Compiler is finalized, if the other one isn't, if the prefix is
+// already imported, or if the prefix isn't a valid name, or has a '.',
+// '(' or ')' in it.
+func (s *Compiler[T]) TryImport(prefix string, other *Compiler[T]) error {
+	if !other.finalized {
+		return &NameError{Name: prefix, Err: ErrNotFinalized,
+			msg: fmt.Sprintf("Can't import '%s'; its objregexp.Compiler is not finalized",
+				prefix)}
+	}
+	if s.finalized {
+		return &NameError{Name: prefix, Err: ErrFinalized,
+			msg: fmt.Sprintf("Can't import '%s'; the objregexp.Compiler is already finalized",
+				prefix)}
+	}
+	for _, imp := range s.imports {
+		if imp.prefix == prefix {
+			return &NameError{Name: prefix, Err: ErrDuplicateName,
+				msg: fmt.Sprintf("The prefix '%s' is already imported", prefix)}
+		}
+	}
+	if strings.ContainsAny(prefix, ".()") {
+		return &NameError{Name: prefix, Err: ErrIllegalName,
+			msg: fmt.Sprintf("The prefix '%s' can't have '.', '(' or ')' in it", prefix)}
+	}
+	if err := CheckName(prefix); err != nil {
+		return err
+	}
+	s.imports = append(s.imports, importT[T]{prefix: prefix, compiler: other})
+	return nil
+}
+
+// Returns all of the names which can be used in regexes, sorted by
+// name. This includes the names in the parent Compilers, unless they
+// are overridden, and the names in imports, with their prefixes. A name
+// from an import can also be used without its prefix, if it's not
+// ambiguous, but it is only listed with it.
+func (s *Compiler[T]) Names() []NameInfo {
+	seen := make(map[string]bool)
+	infos := make([]NameInfo, 0)
+	s.walkCompilers("", func(c *Compiler[T], prefix string) {
+		for name, kind := range c.namespace {
+			name = prefix + name
+			if !seen[name] {
+				seen[name] = true
+				infos = append(infos, NameInfo{Name: name, Kind: NameKind(kind)})
+			}
+		}
+	})
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// Call f on each Compiler whose names can be used in this one, with the
+// prefix that they are used with: first this one and its parents, then
+// their imports. The names in the ones that come first hide the same
+// names in the ones after them.
+func (s *Compiler[T]) walkCompilers(prefix string, f func(c *Compiler[T], prefix string)) {
+	for c := s; c != nil; c = c.parent {
+		f(c, prefix)
+	}
+	for c := s; c != nil; c = c.parent {
+		for _, imp := range c.imports {
+			imp.compiler.walkCompilers(prefix+imp.prefix+".", f)
+		}
+	}
+}
+
+// Returns the keys of the map which keys returns for each Compiler that
+// walkCompilers walks, with their prefixes, sorted, each one once
+func prefixedKeys[T comparable, V any](s *Compiler[T], keys func(*Compiler[T]) map[string]V) []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	s.walkCompilers("", func(c *Compiler[T], prefix string) {
+		for name := range keys(c) {
+			name = prefix + name
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	})
+	sort.Strings(names)
+	return names
+}
+
+// Returns the kind of the name, and true, if it can be used in a
+// regex. Returns false if it's not known, or it's ambiguous.
+func (s *Compiler[T]) Lookup(name string) (NameKind, bool) {
+	ref, _ := s.resolve(name)
+	return NameKind(ref.kind), ref.kind != 0
+}
+
+// Returns the class with the name, and true, if the name refers to a
+// class, or to an identity set, whose class tests if an object is in
+// the set. Names are looked up just as they are in a regex.
+func (s *Compiler[T]) LookupClass(name string) (*Class[T], bool) {
+	ref, _ := s.resolve(name)
+	return ref.class, ref.kind == ccClass || ref.kind == ccIdentitySet
+}
+
+// Returns the object with the name, and true, if the name refers to
+// an identity. Names are looked up just as they are in a regex. If the
+// Compiler has a normalizer, this is the object's key.
+func (s *Compiler[T]) LookupIdentity(name string) (T, bool) {
+	ref, _ := s.resolve(name)
+	return ref.identity, ref.kind == ccIdentity
+}
+
+// Find what the name refers to. Names registered in this Compiler, or
+// its parents, come first. Then a "field=value" name is a class, if the
+// field is registered in them, and so is a "factory(arg)" name, if the
+// class factory is. Then a "prefix.name" is looked up in the import
+// with that prefix. Then the name is looked up in all of the
+// imports; if more than one has it, the name is ambiguous, and their
+// prefixes are returned.
+func (s *Compiler[T]) resolve(name string) (nameRefT[T], []string) {
+	for c := s; c != nil; c = c.parent {
+		if kind, has := c.namespace[name]; has {
+			ref := nameRefT[T]{kind: kind}
+			switch kind {
+			case ccClass, ccIdentitySet:
+				ref.class = c.classMap[name]
+			case ccIdentity:
+				ref.identity = c.identityObj[name]
+				ref.normalizer = c.normalizer
+			}
+			return ref, nil
+		}
+	}
+
+	if i := strings.IndexByte(name, '='); i > 0 {
+		for c := s; c != nil; c = c.parent {
+			if accessor, has := c.fields[name[:i]]; has {
+				return nameRefT[T]{kind: ccClass,
+					class: fieldClass(name, accessor, []string{name[i+1:]})}, nil
+			}
+		}
+	}
+
+	if factory, arg, _, ok := syntax.SplitArg(name); ok {
+		for c := s; c != nil; c = c.parent {
+			if f, has := c.factories[factory]; has {
+				predicate, err := f(arg)
+				if err != nil {
+					return nameRefT[T]{err: err}, nil
+				}
+				return nameRefT[T]{kind: ccClass,
+					class: &Class[T]{Name: name, Matches: predicate}}, nil
+			}
+		}
+	}
+
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		prefix := name[:i]
+		for c := s; c != nil; c = c.parent {
+			for _, imp := range c.imports {
+				if imp.prefix != prefix {
+					continue
+				}
+				ref, ambiguous := imp.compiler.resolve(name[i+1:])
+				for j, p := range ambiguous {
+					ambiguous[j] = prefix + "." + p
+				}
+				if ref.kind != 0 || ref.err != nil || ambiguous != nil {
+					return ref, ambiguous
+				}
+			}
+		}
+	}
+
+	var found nameRefT[T]
+	var prefixes []string
+	for c := s; c != nil; c = c.parent {
+		for _, imp := range c.imports {
+			if ref, _ := imp.compiler.resolve(name); ref.kind != 0 || ref.err != nil {
+				found = ref
+				prefixes = append(prefixes, imp.prefix)
+			}
+		}
+	}
+	if len(prefixes) > 1 {
+		return nameRefT[T]{}, prefixes
+	}
+	return found, nil
+}
+
+// The names resolved in one compile. A name can be resolved many times
+// in a compile, as it is checked and then made into an NFA, and a class
+// factory must only be called once for each of its names.
+type nameCacheT[T comparable] struct {
+	compiler *Compiler[T]
+	refs     map[string]cachedRefT[T]
+}
+
+type cachedRefT[T comparable] struct {
+	ref       nameRefT[T]
+	ambiguous []string
+}
+
+func newNameCache[T comparable](compiler *Compiler[T]) *nameCacheT[T] {
+	return &nameCacheT[T]{
+		compiler: compiler,
+		refs:     make(map[string]cachedRefT[T]),
+	}
+}
+
+// Like Compiler.resolve, but each name is only resolved once
+func (s *nameCacheT[T]) resolve(name string) (nameRefT[T], []string) {
+	cached, has := s.refs[name]
+	if !has {
+		cached.ref, cached.ambiguous = s.compiler.resolve(name)
+		s.refs[name] = cached
+	}
+	return cached.ref, cached.ambiguous
 }
 
 func (s *Compiler[T]) assertFinalized() {
@@ -78,18 +447,21 @@ func (s *Compiler[T]) Finalize() {
 	s.finalized = true
 }
 
-// Registers a user-defined class.
-func (s *Compiler[T]) AddClass(oClass *Class[T]) {
+// Like Finalize, but returns ErrFinalized instead of panicking if
+// the Compiler is already finalized.
+func (s *Compiler[T]) TryFinalize() error {
+	if s.finalized {
+		return ErrFinalized
+	}
+	s.finalized = true
+	return nil
+}
 
-	if t, has := s.namespace[oClass.Name]; has {
-		var msg string
-		switch t {
-		case ccClass:
-			msg = fmt.Sprintf("A class with name '%s' already exists", oClass.Name)
-		case ccIdentity:
-			msg = fmt.Sprintf("An identity with name '%s' already exists", oClass.Name)
-		}
-		panic(msg)
+// Registers a user-defined class. Panics if the name is already
+// registered in this Compiler, or if the Compiler is finalized.
+func (s *Compiler[T]) AddClass(oClass *Class[T]) {
+	if err := s.checkAdd(oClass.Name, "class"); err != nil {
+		panic(err.Error())
 	}
 	s.classMap[oClass.Name] = oClass
 	s.namespace[oClass.Name] = ccClass
@@ -105,9 +477,147 @@ func (s *Compiler[T]) MakeClass(name string, predicate func(T) bool) {
 	s.AddClass(class)
 }
 
-// Registers a user-defined identity.
+// Registers a user-defined identity. Panics if the name is already
+// registered in this Compiler, or if the Compiler is finalized.
 func (s *Compiler[T]) AddIdentity(name string, object T) {
+	if err := s.checkAdd(name, "identity"); err != nil {
+		panic(err.Error())
+	}
+	s.identityObj[name] = s.normalizer.apply(object)
+	s.namespace[name] = ccIdentity
+}
+
+// Like AddClass, but returns a *NameError instead of panicking. The
+// name must also be one that can be written in a regex string; see
+// CheckName.
+func (s *Compiler[T]) TryAddClass(oClass *Class[T]) error {
+	if err := s.checkTryAdd(oClass.Name, "class"); err != nil {
+		return err
+	}
+	s.classMap[oClass.Name] = oClass
+	s.namespace[oClass.Name] = ccClass
+	return nil
+}
 
+// Like MakeClass, but returns a *NameError instead of panicking.
+func (s *Compiler[T]) TryMakeClass(name string, predicate func(T) bool) error {
+	return s.TryAddClass(&Class[T]{
+		Name:    name,
+		Matches: predicate,
+	})
+}
+
+// Like AddIdentity, but returns a *NameError instead of panicking.
+// The name must also be one that can be written in a regex string;
+// see CheckName.
+func (s *Compiler[T]) TryAddIdentity(name string, object T) error {
+	if err := s.checkTryAdd(name, "identity"); err != nil {
+		return err
+	}
+	s.identityObj[name] = s.normalizer.apply(object)
+	s.namespace[name] = ccIdentity
+	return nil
+}
+
+// Registers a set of objects under one name. An object matches the
+// name if it is equal to any of them, as if each one were an identity,
+// but the set is a hash set, so testing an object doesn't depend on
+// the size of the set. The name can be used wherever a class name can.
+// Panics if the name is already registered in this Compiler, or if the
+// Compiler is finalized.
+func (s *Compiler[T]) AddIdentitySet(name string, objects []T) {
+	if err := s.checkAdd(name, "identity set"); err != nil {
+		panic(err.Error())
+	}
+	s.addIdentitySet(name, s.makeIdentitySet(objects))
+}
+
+// Like AddIdentitySet, but the set is given as a map, whose keys are
+// the objects. The map is used as it is, without being copied, so it
+// must not be changed afterwards. If the Compiler has a normalizer,
+// the map is copied, with the keys normalized.
+func (s *Compiler[T]) AddIdentitySetMap(name string, objects map[T]struct{}) {
+	if err := s.checkAdd(name, "identity set"); err != nil {
+		panic(err.Error())
+	}
+	s.addIdentitySet(name, s.makeIdentitySetMap(objects))
+}
+
+// Like AddIdentitySet, but returns a *NameError instead of panicking.
+// The name must also be one that can be written in a regex string;
+// see CheckName.
+func (s *Compiler[T]) TryAddIdentitySet(name string, objects []T) error {
+	if err := s.checkTryAdd(name, "identity set"); err != nil {
+		return err
+	}
+	s.addIdentitySet(name, s.makeIdentitySet(objects))
+	return nil
+}
+
+// Like AddIdentitySetMap, but returns a *NameError instead of panicking.
+// The name must also be one that can be written in a regex string;
+// see CheckName.
+func (s *Compiler[T]) TryAddIdentitySetMap(name string, objects map[T]struct{}) error {
+	if err := s.checkTryAdd(name, "identity set"); err != nil {
+		return err
+	}
+	s.addIdentitySet(name, s.makeIdentitySetMap(objects))
+	return nil
+}
+
+// Make a set of the keys of the objects
+func (s *Compiler[T]) makeIdentitySet(objects []T) *identitySetT[T] {
+	keys := make([]T, len(objects))
+	for i, o := range objects {
+		keys[i] = s.normalizer.apply(o)
+	}
+	return newIdentitySet(keys, s.normalizer, s.hashable)
+}
+
+// Like makeIdentitySet, but the objects are the keys of a map, which
+// is used as the set if it can be
+func (s *Compiler[T]) makeIdentitySetMap(objects map[T]struct{}) *identitySetT[T] {
+	if !s.hashable {
+		keys := make([]T, 0, len(objects))
+		for o := range objects {
+			keys = append(keys, s.normalizer.apply(o))
+		}
+		return newIdentitySet(keys, s.normalizer, s.hashable)
+	}
+	return &identitySetT[T]{set: normalizeSet(objects, s.normalizer),
+		normalizer: s.normalizer}
+}
+
+// Returns a set of the keys of the objects in the set; if there is no
+// normalizer, that is the set itself
+func normalizeSet[T comparable](set map[T]struct{}, normalizer *normalizerT[T]) map[T]struct{} {
+	if normalizer == nil {
+		return set
+	}
+	keys := make(map[T]struct{}, len(set))
+	for o := range set {
+		keys[normalizer.apply(o)] = struct{}{}
+	}
+	return keys
+}
+
+// The set is kept as a class, so that everything that can use a
+// class can use it
+func (s *Compiler[T]) addIdentitySet(name string, set *identitySetT[T]) {
+	s.classMap[name] = &Class[T]{
+		Name:    name,
+		Matches: set.has,
+	}
+	s.namespace[name] = ccIdentitySet
+}
+
+// Can the class or identity be added? kind is used in the message
+func (s *Compiler[T]) checkAdd(name string, kind string) *NameError {
+	if s.finalized {
+		return &NameError{Name: name, Err: ErrFinalized,
+			msg: fmt.Sprintf("Can't add the %s '%s'; the objregexp.Compiler is already finalized",
+				kind, name)}
+	}
 	if t, has := s.namespace[name]; has {
 		var msg string
 		switch t {
@@ -115,18 +625,72 @@ func (s *Compiler[T]) AddIdentity(name string, object T) {
 			msg = fmt.Sprintf("A class with name '%s' already exists", name)
 		case ccIdentity:
 			msg = fmt.Sprintf("An identity with name '%s' already exists", name)
+		case ccIdentitySet:
+			msg = fmt.Sprintf("An identity set with name '%s' already exists", name)
 		}
-		panic(msg)
+		return &NameError{Name: name, Err: ErrDuplicateName, msg: msg}
 	}
-	s.identityObj[name] = object
-	s.namespace[name] = ccIdentity
+	return nil
+}
+
+// Like checkAdd, but the name must be one that can be written in a regex
+func (s *Compiler[T]) checkTryAdd(name string, kind string) error {
+	if err := s.checkAdd(name, kind); err != nil {
+		return err
+	}
+	return CheckName(name)
+}
+
+// CheckName returns a *NameError, wrapping ErrIllegalName, if the name
+// can't be written in a regex string as "[:name:]". A name must be
+// valid UTF-8, can't be empty, and can only have graphic code points
+// and spaces in it, except for ':' and ']'. '(' and ')' are for the
+// arguments of class factories, so a name can only have them as a
+// "factory(arg)" name does, or in the value of a "field=value" name; a
+// class registered with that name is used instead of calling the
+// factory, or the field accessor.
+func CheckName(name string) error {
+	var msg string
+	switch {
+	case name == "":
+		msg = "A class or identity name can't be empty"
+	case !utf8.ValidString(name):
+		msg = fmt.Sprintf("The name '%s' isn't valid UTF-8", name)
+	case strings.ContainsAny(name, ":]"):
+		msg = fmt.Sprintf("The name '%s' can't have ':' or ']' in it", name)
+	case strings.ContainsAny(name, "()") && !parensAllowed(name):
+		msg = fmt.Sprintf("The name '%s' can only have '(' and ')' around an argument at its end",
+			name)
+	default:
+		for _, r := range name {
+			if !unicode.IsGraphic(r) && r != ' ' {
+				msg = fmt.Sprintf("The name '%s' has a non-graphic code point in it", name)
+				break
+			}
+		}
+	}
+	if msg == "" {
+		return nil
+	}
+	return &NameError{Name: name, Err: ErrIllegalName, msg: msg}
+}
+
+// Are the parens in the name allowed? They must be around the argument
+// at the end of a "factory(arg)" name, unless the name is a
+// "field=value" name, whose value can have any parens in it.
+func parensAllowed(name string) bool {
+	if strings.Contains(name[:strings.IndexAny(name, "()")], "=") {
+		return true
+	}
+	factory, arg, _, ok := syntax.SplitArg(name)
+	return ok && !strings.ContainsAny(factory, "()") && !strings.ContainsAny(arg, "()")
 }
 
 // Compile a regex string into a Regexp object.
 // An error is returned if there is a syntax error.
 func (s *Compiler[T]) Compile(text string) (*Regexp[T], error) {
 	if !s.finalized {
-		return nil, fmt.Errorf("The objregexp.Compiler is not finalized. Call Finalize().")
+		return nil, fmt.Errorf("%w. Call Finalize().", ErrNotFinalized)
 	}
 
 	factory := newNfaFactory[T](s)
@@ -3,7 +3,10 @@
 package objregexp
 
 import (
-	. "gopkg.in/check.v1"
+	"errors"
+	"strings"
+
+	. "github.com/gilramir/objregexp/internal/check"
 )
 
 var vowels = []rune{'A', 'E', 'I', 'O', 'U', 'a', 'e', 'i', 'o', 'u'}
@@ -622,3 +625,380 @@ func (s *MySuite) TestAssertEnd02(c *C) {
 	m = re.Match(input)
 	c.Check(m.Success, Equals, false)
 }
+
+// Run with "go test -check.b"
+func (s *MySuite) BenchmarkCompile01(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(ConsonantClass)
+	compiler.AddClass(UpperClass)
+	compiler.AddClass(DigitClass)
+	compiler.AddIdentity("x", 'x')
+	compiler.Finalize()
+
+	text := `^ (?P<first>[:vowel:]+) [:consonant: && !:upper:]{2,5}?
+		(?:[:digit:] | [:x: || (:upper: && :vowel:)])* (?=[!:x:]) \1 $`
+	for i := 0; i < c.N; i++ {
+		_, err := compiler.Compile(text)
+		if err != nil {
+			c.Fatal(err)
+		}
+	}
+}
+
+func (s *MySuite) TestTryAdd01(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+
+	// Compiling and building need a finalized Compiler
+	_, err := compiler.Compile("[:vowel:]")
+	c.Check(errors.Is(err, ErrNotFinalized), Equals, true)
+	c.Check(err, ErrorMatches, "The objregexp.Compiler is not finalized. Call Finalize\\(\\).")
+	_, err = compiler.Build(Any())
+	c.Check(errors.Is(err, ErrNotFinalized), Equals, true)
+
+	c.Assert(compiler.TryAddClass(VowelClass), IsNil)
+	c.Assert(compiler.TryAddIdentity("big a", 'A'), IsNil)
+	c.Assert(compiler.TryMakeClass("ünïcödé", func(r rune) bool { return r > 127 }), IsNil)
+
+	err = compiler.TryAddClass(VowelClass)
+	c.Check(errors.Is(err, ErrDuplicateName), Equals, true)
+	c.Check(err, ErrorMatches, "A class with name 'vowel' already exists")
+
+	err = compiler.TryAddIdentity("vowel", 'a')
+	c.Check(errors.Is(err, ErrDuplicateName), Equals, true)
+
+	err = compiler.TryAddIdentity("big a", 'a')
+	c.Check(err, ErrorMatches, "An identity with name 'big a' already exists")
+
+	for _, name := range []string{"", "a:b", "a]", "tab\there", "\xff",
+		"a(b", "a)b", "(a)", "a(b)c", "a(b(c))", "a)b(c)"} {
+		err = compiler.TryAddIdentity(name, 'a')
+		c.Check(errors.Is(err, ErrIllegalName), Equals, true, Commentf(name))
+		var nameErr *NameError
+		c.Assert(errors.As(err, &nameErr), Equals, true)
+		c.Check(nameErr.Name, Equals, name)
+	}
+
+	// Parens are only allowed around an argument at the end, as a
+	// regex string can only have them there
+	c.Check(CheckName("a(b c)"), IsNil)
+	c.Check(CheckName("a(b"), ErrorMatches,
+		"The name 'a\\(b' can only have '\\(' and '\\)' around an argument at its end")
+
+	c.Assert(compiler.TryFinalize(), IsNil)
+	c.Check(compiler.TryFinalize(), Equals, ErrFinalized)
+
+	err = compiler.TryAddClass(ConsonantClass)
+	c.Check(errors.Is(err, ErrFinalized), Equals, true)
+	c.Check(err, ErrorMatches, "Can't add the class 'consonant'; .*")
+	c.Check(func() { compiler.AddClass(ConsonantClass) }, PanicMatches,
+		"Can't add the class 'consonant'; .*")
+
+	re := compiler.MustCompile("[:vowel:] [:big a:] [:ünïcödé:]")
+	c.Check(re.FullMatch([]rune("eAé")).Success, Equals, true)
+}
+
+func (s *MySuite) TestNewChild01(c *C) {
+	var parent Compiler[rune]
+	parent.Initialize()
+	parent.AddClass(VowelClass)
+	parent.AddClass(DigitClass)
+	parent.AddIdentity("x", 'x')
+
+	c.Check(func() { parent.NewChild() }, PanicMatches,
+		"objregexp.Compiler isn't finalized yet")
+	parent.Finalize()
+
+	child := parent.NewChild()
+	child.AddClass(ConsonantClass)
+	// Overrides the parent's identity, and class
+	child.AddIdentity("x", 'X')
+	child.AddIdentity("digit", '0')
+	c.Check(child.TryAddIdentity("x", 'y'), ErrorMatches,
+		"An identity with name 'x' already exists")
+
+	_, err := child.Compile("[:vowel:]")
+	c.Check(err, ErrorMatches, ".*not finalized.*")
+	child.Finalize()
+
+	re := child.MustCompile("[:vowel:] [:consonant:] [:x:] [:digit: || :x:]")
+	c.Check(re.FullMatch([]rune("abX0")).Success, Equals, true)
+	c.Check(re.FullMatch([]rune("abx0")).Success, Equals, false)
+	c.Check(re.FullMatch([]rune("abX5")).Success, Equals, false)
+
+	re = child.MustBuild(Seq(ClassRef("vowel"), IdentityRef("digit")))
+	c.Check(re.FullMatch([]rune("a0")).Success, Equals, true)
+
+	// The parent doesn't see the child's names
+	_, err = parent.Compile("[:consonant:]")
+	c.Check(err, ErrorMatches, "No such class or identity name 'consonant' at pos 1")
+	re = parent.MustCompile("[:x:] [:digit:]")
+	c.Check(re.FullMatch([]rune("x5")).Success, Equals, true)
+
+	// A grandchild sees them all
+	grandchild := child.NewChild()
+	grandchild.AddClass(UpperClass)
+	grandchild.Finalize()
+	re = grandchild.MustCompile("[:upper:] [:consonant:] [:x:] [:vowel:]")
+	c.Check(re.FullMatch([]rune("AbXe")).Success, Equals, true)
+}
+
+func (s *MySuite) TestImport01(c *C) {
+	var pos Compiler[rune]
+	pos.Initialize()
+	pos.AddClass(VowelClass)
+	pos.AddIdentity("x", 'x')
+	pos.AddIdentity("only pos", 'p')
+
+	var ner Compiler[rune]
+	ner.Initialize()
+	ner.AddClass(DigitClass)
+	ner.AddIdentity("x", 'X')
+
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	err := compiler.TryImport("pos", &pos)
+	c.Check(errors.Is(err, ErrNotFinalized), Equals, true)
+	pos.Finalize()
+	ner.Finalize()
+
+	compiler.Import("pos", &pos)
+	compiler.Import("ner", &ner)
+	compiler.AddIdentity("vowel", 'v')
+	c.Check(errors.Is(compiler.TryImport("pos", &ner), ErrDuplicateName), Equals, true)
+	c.Check(errors.Is(compiler.TryImport("a.b", &ner), ErrIllegalName), Equals, true)
+	c.Check(errors.Is(compiler.TryImport("a:b", &ner), ErrIllegalName), Equals, true)
+	c.Check(errors.Is(compiler.TryImport("a(b)", &ner), ErrIllegalName), Equals, true)
+	compiler.Finalize()
+
+	// Qualified names, in plain and dynamic classes
+	re := compiler.MustCompile("[:pos.x:] [:ner.x:] [:pos.vowel: && !:ner.digit:] [:ner.digit:]")
+	c.Check(re.FullMatch([]rune("xXa5")).Success, Equals, true)
+	c.Check(re.FullMatch([]rune("Xxa5")).Success, Equals, false)
+
+	// The compiler's own names come first, and unique ones need no prefix
+	re = compiler.MustCompile("[:vowel:] [:digit:] [:only pos:]")
+	c.Check(re.FullMatch([]rune("v5p")).Success, Equals, true)
+	c.Check(re.FullMatch([]rune("a5p")).Success, Equals, false)
+
+	_, err = compiler.Compile("[:x:]")
+	c.Check(err, ErrorMatches,
+		"The name 'x' at pos 1 is ambiguous; it is in the imports pos, ner")
+	var serr *SyntaxError
+	c.Assert(errors.As(err, &serr), Equals, true)
+	c.Check(serr.Code, Equals, ErrAmbiguousName)
+
+	_, err = compiler.Compile("[:digit: || :x:]")
+	c.Check(err, ErrorMatches,
+		"Parsing class string at pos 1: Class :x: at pos 12 is ambiguous; it is in the imports pos, ner")
+
+	_, err = compiler.Compile("[:pos.digit:]")
+	c.Check(err, ErrorMatches, "No such class or identity name 'pos.digit' at pos 1")
+
+	_, err = compiler.Build(IdentityRef("x"))
+	c.Check(err, ErrorMatches, "The name 'x' is ambiguous; it is in the imports pos, ner")
+	re = compiler.MustBuild(Seq(IdentityRef("ner.x"), ClassRef("pos.vowel")))
+	c.Check(re.FullMatch([]rune("Xe")).Success, Equals, true)
+
+	// Imports are inherited, and can be imported again
+	child := compiler.NewChild()
+	child.Finalize()
+	re = child.MustCompile("[:ner.x:]")
+	c.Check(re.FullMatch([]rune("X")).Success, Equals, true)
+
+	var top Compiler[rune]
+	top.Initialize()
+	top.Import("all", &compiler)
+	top.Finalize()
+	re = top.MustCompile("[:all.pos.x:] [:all.digit:]")
+	c.Check(re.FullMatch([]rune("x0")).Success, Equals, true)
+	_, err = top.Compile("[:all.x:]")
+	c.Check(err, ErrorMatches,
+		"The name 'all.x' at pos 1 is ambiguous; it is in the imports all.pos, all.ner")
+}
+
+func (s *MySuite) TestIntrospection01(c *C) {
+	var ner Compiler[rune]
+	ner.Initialize()
+	ner.AddIdentity("x", 'X')
+	ner.Finalize()
+
+	var parent Compiler[rune]
+	parent.Initialize()
+	parent.AddClass(VowelClass)
+	parent.AddIdentity("digit", '0')
+	parent.Finalize()
+
+	compiler := parent.NewChild()
+	compiler.AddClass(DigitClass)
+	compiler.AddIdentity("x", 'x')
+	compiler.Import("ner", &ner)
+	compiler.Finalize()
+
+	c.Check(compiler.Names(), DeepEquals, []NameInfo{
+		{"digit", KindClass},
+		{"ner.x", KindIdentity},
+		{"vowel", KindClass},
+		{"x", KindIdentity},
+	})
+	c.Check(KindIdentity.String(), Equals, "identity")
+
+	kind, ok := compiler.Lookup("ner.x")
+	c.Check(ok, Equals, true)
+	c.Check(kind, Equals, KindIdentity)
+	_, ok = compiler.Lookup("nope")
+	c.Check(ok, Equals, false)
+
+	class, ok := compiler.LookupClass("digit")
+	c.Check(ok, Equals, true)
+	c.Check(class, Equals, DigitClass)
+	_, ok = compiler.LookupClass("x")
+	c.Check(ok, Equals, false)
+
+	obj, ok := compiler.LookupIdentity("ner.x")
+	c.Check(ok, Equals, true)
+	c.Check(obj, Equals, 'X')
+	_, ok = compiler.LookupIdentity("vowel")
+	c.Check(ok, Equals, false)
+
+	re := compiler.MustCompile("[:x:] ([:vowel: || :ner.x:])+ [:x:] [!:digit:]")
+	c.Check(re.Names(), DeepEquals, []string{"x", "vowel", "ner.x", "digit"})
+
+	re = compiler.MustBuild(Seq(ClassRef("digit"), Any()))
+	c.Check(re.Names(), DeepEquals, []string{"digit"})
+
+	re = compiler.MustCompile(".")
+	c.Check(re.Names(), HasLen, 0)
+}
+
+func (s *MySuite) TestIdentitySet01(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(LowerClass)
+	compiler.AddIdentitySet("vowel", vowels)
+	compiler.AddIdentitySetMap("odd", map[rune]struct{}{'1': {}, '3': {}, '5': {}, '7': {}, '9': {}})
+	c.Check(func() { compiler.AddIdentitySet("vowel", nil) }, PanicMatches,
+		"An identity set with name 'vowel' already exists")
+	c.Check(errors.Is(compiler.TryAddIdentitySet("a:b", nil), ErrIllegalName), Equals, true)
+	c.Check(compiler.TryAddIdentitySetMap("even", map[rune]struct{}{'2': {}, '4': {}}), IsNil)
+	compiler.Finalize()
+
+	kind, _ := compiler.Lookup("vowel")
+	c.Check(kind, Equals, KindIdentitySet)
+	class, ok := compiler.LookupClass("odd")
+	c.Assert(ok, Equals, true)
+	c.Check(class.Matches('3'), Equals, true)
+	c.Check(class.Matches('4'), Equals, false)
+
+	re := compiler.MustCompile("[:vowel:]+ [!:vowel:] [:odd: || :even:] [:lower: && !:vowel:]")
+	c.Check(re.FullMatch([]rune("aEz3q")).Success, Equals, true)
+	c.Check(re.FullMatch([]rune("aEz3e")).Success, Equals, false)
+	c.Check(re.FullMatch([]rune("aEz6q")).Success, Equals, false)
+	c.Check(re.FullMatch([]rune("aEe3q")).Success, Equals, false)
+
+	re = compiler.MustBuild(Seq(ClassRef("odd"), Not(ClassRef("vowel"))))
+	c.Check(re.FullMatch([]rune("5x")).Success, Equals, true)
+	_, err := compiler.Build(IdentityRef("odd"))
+	c.Check(err, ErrorMatches, "'odd' is an identity set, not an identity")
+}
+
+func (s *MySuite) TestIdentitySet02(c *C) {
+	// An interface T can hold objects which can't be looked up in a map
+	compiler := NewCompiler[any]()
+	compiler.AddIdentitySet("stop", []any{"the", []int{1}})
+	c.Check(compiler.TryAddIdentitySet("article", []any{"a", []int{2}, map[string]int{}}), IsNil)
+	compiler.AddIdentitySetMap("number", map[any]struct{}{1: {}, 2: {}})
+	compiler.Finalize()
+
+	class, ok := compiler.LookupClass("stop")
+	c.Assert(ok, Equals, true)
+	c.Check(class.Matches("the"), Equals, true)
+	c.Check(class.Matches([]int{1}), Equals, false)
+
+	re := compiler.MustCompile("[:stop:]")
+	c.Check(re.FullMatch([]any{"the"}).Success, Equals, true)
+	c.Check(re.FullMatch([]any{[]string{"x"}}).Success, Equals, false)
+	c.Check(re.FullMatch([]any{[]int{1}}).Success, Equals, false)
+	re = compiler.MustCompile("[:article: || :number:] [!:stop:]")
+	c.Check(re.FullMatch([]any{"a", []int{1}}).Success, Equals, true)
+	c.Check(re.FullMatch([]any{2, map[string]int{}}).Success, Equals, true)
+	c.Check(re.FullMatch([]any{[]int{2}, "x"}).Success, Equals, false)
+
+	// And so can a struct with an interface in it
+	type boxT struct{ v any }
+	boxes := NewCompiler[boxT]()
+	boxes.AddIdentitySet("small", []boxT{{1}, {[]int{1}}, {nil}})
+	boxes.Finalize()
+	boxRe := boxes.MustCompile("[:small:]+")
+	c.Check(boxRe.FullMatch([]boxT{{1}, {nil}}).Success, Equals, true)
+	c.Check(boxRe.FullMatch([]boxT{{1}, {[]int{1}}}).Success, Equals, false)
+	c.Check(boxRe.FullMatch([]boxT{{map[int]int{}}}).Success, Equals, false)
+}
+
+func (s *MySuite) TestNormalizer01(c *C) {
+	var compiler Compiler[string]
+	compiler.Initialize()
+	compiler.SetNormalizer(strings.ToLower)
+	compiler.AddIdentity("the", "The")
+	compiler.AddIdentity("a", "a")
+	compiler.AddIdentity("an", "AN")
+	compiler.AddIdentitySet("noun", []string{"Cat", "dog"})
+	compiler.AddIdentitySetMap("verb", map[string]struct{}{"Sat": {}, "RAN": {}})
+	compiler.MakeClass("capitalized", func(w string) bool {
+		return w != "" && w[:1] == strings.ToUpper(w[:1])
+	})
+	c.Check(func() { compiler.SetNormalizer(strings.ToUpper) }, PanicMatches,
+		"SetNormalizer must be called before identities are added")
+	compiler.Finalize()
+
+	obj, _ := compiler.LookupIdentity("an")
+	c.Check(obj, Equals, "an")
+
+	re := compiler.MustCompile("[:the:] [:noun:] [:verb:]")
+	c.Check(re.FullMatch([]string{"THE", "CAT", "sat"}).Success, Equals, true)
+	c.Check(re.FullMatch([]string{"a", "cat", "sat"}).Success, Equals, false)
+
+	// A map lookup, and a dynamic class
+	re = compiler.MustCompile("[:the:] | [:a:] | [:an:]")
+	c.Check(re.nfa.c, Equals, ntIdentitySet)
+	c.Check(re.Search([]string{"x", "An"}).Range, Equals, Range{1, 2})
+	re = compiler.MustCompile("[:a: || :capitalized:] [!:an:]")
+	c.Check(re.FullMatch([]string{"A", "dog"}).Success, Equals, true)
+	c.Check(re.FullMatch([]string{"Zoo", "dog"}).Success, Equals, true)
+	c.Check(re.FullMatch([]string{"zoo", "dog"}).Success, Equals, false)
+	c.Check(re.FullMatch([]string{"a", "An"}).Success, Equals, false)
+
+	// Classes get the objects as they are
+	re = compiler.MustCompile("[:capitalized:]")
+	c.Check(re.FullMatch([]string{"the"}).Success, Equals, false)
+
+	// Backreferences compare keys
+	re = compiler.MustCompile("(.) [:noun:] \\1")
+	c.Check(re.FullMatch([]string{"big", "dog", "BIG"}).Success, Equals, true)
+	c.Check(re.FullMatch([]string{"big", "dog", "bag"}).Success, Equals, false)
+
+	// A child uses its parent's normalizer
+	child := compiler.NewChild()
+	child.AddIdentity("dog", "DOG")
+	c.Check(func() { child.SetNormalizer(strings.ToUpper) }, PanicMatches,
+		".*uses its parent's normalizer")
+	child.Finalize()
+	re = child.MustCompile("[:the:] [:dog:]")
+	c.Check(re.FullMatch([]string{"the", "Dog"}).Success, Equals, true)
+
+	// An import's identities are compared with its own normalizer
+	var plain Compiler[string]
+	plain.Initialize()
+	plain.AddIdentity("Cat", "Cat")
+	plain.AddIdentity("Dog", "Dog")
+	plain.Import("en", &compiler)
+	plain.Finalize()
+	re = plain.MustCompile("[:en.the: || :en.a:] [:Cat: || :Dog:]")
+	c.Check(re.FullMatch([]string{"THE", "Cat"}).Success, Equals, true)
+	c.Check(re.FullMatch([]string{"THE", "cat"}).Success, Equals, false)
+	re = plain.MustCompile("[:en.the: || :Cat: || :en.a:]")
+	c.Check(re.FullMatch([]string{"A"}).Success, Equals, true)
+	c.Check(re.FullMatch([]string{"CAT"}).Success, Equals, false)
+}
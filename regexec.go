@@ -11,12 +11,20 @@ import (
 type executorT[T comparable] struct {
 	regex *Regexp[T]
 
+	// The input objects, which assertions need to look at
+	input []T
+
 	// The integer which represents the starting position before
 	// the first one. If we start matching at pos 0, prePos is -1
 	// This isn't used for the register -1 value (uninitialized).
 	// It's only for the "pos" in addstate()
 	prePos int
 
+	// Is this running a lookbehind's NFA, which reads the input
+	// backwards? Then "pos" in addstate() still means the position
+	// before the boundary, but the objects are stepped in reverse.
+	reverse bool
+
 	// for stepping through the input objects, we need to keep track
 	// of each list, so we don't add an exStateT to it if it's already in
 	// it.
@@ -27,14 +35,26 @@ type executorT[T comparable] struct {
 	stCache map[*nfaStateT[T]]*exStateT[T]
 
 	matchstate *exStateT[T]
+
+	// If the regex has backreferences, a state can be added to a list
+	// more than once, once for each different set of registers. This
+	// records what was added to the list with the id addedListid.
+	added       map[string]bool
+	addedListid int
 }
 
 // Initialize an executorT from a Regexp
 func (s *executorT[T]) Initialize(regex *Regexp[T]) {
+	s.initialize(regex, &regex.matchstate)
+}
+
+// Initialize an executorT for an NFA in the Regexp, whose matching
+// state is given. This can be the Regexp's NFA, or an assertion's.
+func (s *executorT[T]) initialize(regex *Regexp[T], matchstate *nfaStateT[T]) {
 	s.regex = regex
 	s.listid = 0
 	s.stCache = make(map[*nfaStateT[T]]*exStateT[T])
-	s.matchstate = s.exState(&regex.matchstate)
+	s.matchstate = s.exState(matchstate)
 }
 
 // This mirrors a state object, but it's modifiable so that the same
@@ -140,6 +160,8 @@ type hitT[T comparable] struct {
 // If full is true, wait until the end of the string to check for a final match
 // If full is false, return true as soon as a match is found
 func (s *executorT[T]) match(start *nfaStateT[T], input []T, from int, full bool) (bool, int, *nfaRegStateT[T]) {
+	s.input = input
+	s.prePos = from - 1
 	ok, count, xns := s._match(start, input, from, full)
 	if ok { //&& xns != nil {
 		// It's possible for us to have -1's on one side (start/end)
@@ -167,6 +189,27 @@ func (s *executorT[T]) match(start *nfaStateT[T], input []T, from int, full bool
 type nfaRegStateT[T comparable] struct {
 	root      *exStateT[T]
 	registers *registersT
+
+	// Did this state come from a lazy split choosing to repeat
+	// its glob, instead of leaving it?
+	deferred bool
+
+	// If root is a backreference, how many of the captured objects
+	// have already been matched
+	brPos int
+}
+
+// The states in a list are in priority order. Remove the deferred
+// states which have a lower priority than the match at index mi;
+// a lazy glob will have preferred that match over them.
+func dropDeferred[T comparable](l []*nfaRegStateT[T], mi int) []*nfaRegStateT[T] {
+	keep := l[:mi+1]
+	for _, nsr := range l[mi+1:] {
+		if !nsr.deferred {
+			keep = append(keep, nsr)
+		}
+	}
+	return keep
 }
 
 func (s *executorT[T]) _match(start *nfaStateT[T], input []T, from int, full bool) (bool, int, *nfaRegStateT[T]) {
@@ -179,8 +222,7 @@ func (s *executorT[T]) _match(start *nfaStateT[T], input []T, from int, full boo
 	s.listid++
 	dlog.Printf("calling addstate on root nfa")
 
-	s.prePos = from - 1
-	clist = s.addstate(s.prePos, clist, &nfaRegStateT[T]{xstart, s.newRegisters()})
+	clist = s.addstate(from-1, clist, &nfaRegStateT[T]{xstart, s.newRegisters(), false, 0})
 
 	// Keep track of matches because we want to be a little greedy
 	// and not return too early
@@ -196,7 +238,7 @@ func (s *executorT[T]) _match(start *nfaStateT[T], input []T, from int, full boo
 			dlog.Printf("(len=0) clist item #%d regs:%v\n%s", cxi, cxsr.registers.ranges, cxs.Repr())
 			ns := cxs.st
 			if ns.c == ntMatch {
-				if matched, xns := s.ismatch(0, clist); matched {
+				if matched, _, xns := s.ismatch(0, clist); matched {
 					hit = hitT[T]{x: xns, length: 0}
 					dlog.Printf("MATCHED and stored hit %+v", hit)
 					return true, hit.length, hit.x
@@ -220,6 +262,18 @@ func (s *executorT[T]) _match(start *nfaStateT[T], input []T, from int, full boo
 		}
 		// fall through
 	} else {
+		// A lazy glob, like "[:a:]??", may prefer to match nothing
+		// at all, over the states it deferred. An empty match which
+		// no lazy glob prefers is not a match on non-empty input.
+		if !full {
+			if matched, mi, xns := s.ismatch(from, clist); matched {
+				if kept := dropDeferred(clist, mi); len(kept) < len(clist) {
+					hit = hitT[T]{x: xns, length: 0}
+					dlog.Printf("MATCHED and stored hit %+v", hit)
+					clist = kept
+				}
+			}
+		}
 		for i := from; i < len(input); i++ {
 			ch := input[i]
 			dlog.Printf("=========================================")
@@ -235,10 +289,12 @@ func (s *executorT[T]) _match(start *nfaStateT[T], input []T, from int, full boo
 			clist, nlist = nlist, clist
 
 			if !full {
-				if matched, xns := s.ismatch(i+1, clist); matched {
+				if matched, mi, xns := s.ismatch(i+1, clist); matched {
 					hit = hitT[T]{x: xns, length: i - from + 1}
 					dlog.Printf("MATCHED and stored hit %+v", hit)
-					// keep going
+					// keep going, but not with the states that
+					// a lazy glob likes less than this match.
+					clist = dropDeferred(clist, mi)
 				} else {
 					dlog.Printf("NO MATCH; prev hit was %+v\n", hit)
 					if hit.x != nil {
@@ -254,7 +310,7 @@ func (s *executorT[T]) _match(start *nfaStateT[T], input []T, from int, full boo
 	// After the loop, match any $ tokens
 	if full {
 		// If looking for a full match, did we match the $ at the end?
-		if matched, xns := s.ismatch(haystackSize, clist); matched {
+		if matched, _, xns := s.ismatch(haystackSize, clist); matched {
 			return true, haystackSize, xns
 		} else {
 			return false, 0, nil
@@ -279,33 +335,26 @@ func (s *executorT[T]) _match(start *nfaStateT[T], input []T, from int, full boo
 func (s *executorT[T]) addstate(pos int, l []*nfaRegStateT[T], nsx *nfaRegStateT[T]) []*nfaRegStateT[T] {
 	ns := nsx.root
 	regs := nsx.registers
-	if ns == nil || ns.lastlist == s.listid {
+	if ns == nil || s.alreadyAdded(nsx) {
 		return l
 	}
-	ns.lastlist = s.listid
 	dlog.Printf("in addstate at pos %d, ns=%s l list has %d items:", pos, ns.Repr0(), len(l))
 	for li, lnx := range l {
 		lx := lnx.root
 		dlog.Printf("state #%d: reg:%v\n%s", li, lnx.registers.ranges, lx.Repr())
 	}
 	if ns.st.c == ntSplit {
-		for _, rn := range ns.st.startsRegisters {
-			dlog.Printf("addstate setting start reg #%d = pos %d", rn, pos)
-			// The matching character starts this register,
-			regs.ranges[rn-1].Start = pos + 1
-		}
-		for _, rn := range ns.st.endsRegisters {
-			dlog.Printf("addstate setting end reg #%d = pos %d", rn, pos)
-			// The end paren is this pos, but we record pos+1
-			// to be more like Go slices
-			// check that start was seen first; it won't be
-			// in "*" glob
-			if regs.ranges[rn-1].Start != -1 {
-				regs.ranges[rn-1].End = pos + 1
-			}
+		s.zeroWidthRegisters(pos, ns.st, regs)
+
+		// The order in which the states are added is their priority.
+		// A lazy split prefers to skip (or leave) the glob.
+		if ns.st.lazy {
+			l = s.addstate(pos, l, &nfaRegStateT[T]{ns.out1, nsx.registers.Copy(), nsx.deferred, 0})
+			l = s.addstate(pos, l, &nfaRegStateT[T]{ns.out, nsx.registers.Copy(), true, 0})
+		} else {
+			l = s.addstate(pos, l, &nfaRegStateT[T]{ns.out, nsx.registers.Copy(), nsx.deferred, 0})
+			l = s.addstate(pos, l, &nfaRegStateT[T]{ns.out1, nsx.registers.Copy(), nsx.deferred, 0})
 		}
-		l = s.addstate(pos, l, &nfaRegStateT[T]{ns.out, nsx.registers.Copy()})
-		l = s.addstate(pos, l, &nfaRegStateT[T]{ns.out1, nsx.registers.Copy()})
 
 		// This return is missing in
 		// https://medium.com/@phanindramoganti/regex-under-the-hood-implementing-a-simple-regex-compiler-in-go-ef2af5c6079
@@ -316,15 +365,151 @@ func (s *executorT[T]) addstate(pos int, l []*nfaRegStateT[T], nsx *nfaRegStateT
 	}
 	if ns.st.c == ntMeta && ns.st.meta == mtAssertBegin {
 		if pos == s.prePos {
-			l = s.addstate(pos, l, &nfaRegStateT[T]{ns.out, nsx.registers.Copy()})
+			s.zeroWidthRegisters(pos, ns.st, regs)
+			l = s.addstate(pos, l, &nfaRegStateT[T]{ns.out, nsx.registers.Copy(), nsx.deferred, 0})
 		}
 		// if pos > s.prePos, ^ won't match, so don't add it
+	} else if ns.st.c == ntMeta && ns.st.meta == mtAssertEnd && s.reverse {
+		// Running backwards, "$" is seen before any object is read
+		if pos+1 == len(s.input) {
+			l = s.addstate(pos, l, &nfaRegStateT[T]{ns.out, nsx.registers.Copy(), nsx.deferred, 0})
+		}
+	} else if ns.st.c == ntMeta && (ns.st.meta == mtLookahead || ns.st.meta == mtLookbehind) {
+		// The assertion looks at the objects after (or before) pos
+		if s.assertionMatches(ns.st, pos+1, regs) != ns.st.negation {
+			s.zeroWidthRegisters(pos, ns.st, regs)
+			l = s.addstate(pos, l, &nfaRegStateT[T]{ns.out, nsx.registers.Copy(), nsx.deferred, 0})
+		}
+	} else if ns.st.c == ntBackref && nsx.brPos == 0 {
+		r := regs.ranges[ns.st.regNum-1]
+		if r.Start == -1 || r.End == -1 {
+			// The group didn't match anything, so neither
+			// does the backreference
+		} else if r.Start == r.End {
+			// The group matched nothing, so this is zero-width
+			s.zeroWidthRegisters(pos, ns.st, regs)
+			l = s.addstate(pos, l, &nfaRegStateT[T]{ns.out, nsx.registers.Copy(), nsx.deferred, 0})
+		} else {
+			// The registers start at the first object, but
+			// step() only sees the last one.
+			for _, rn := range ns.st.startsRegisters {
+				if regs.ranges[rn-1].Start == -1 {
+					regs.ranges[rn-1].Start = pos + 1
+				}
+			}
+			l = append(l, nsx)
+		}
 	} else {
 		l = append(l, nsx)
 	}
 	return l
 }
 
+// Has this state already been added to the list being built?
+// If not, it is recorded as added.
+func (s *executorT[T]) alreadyAdded(nsx *nfaRegStateT[T]) bool {
+	ns := nsx.root
+	if !s.regex.hasBackrefs {
+		if ns.lastlist == s.listid {
+			return true
+		}
+		ns.lastlist = s.listid
+		return false
+	}
+
+	// A backreference depends on the registers, so states
+	// with different registers are different.
+	if s.added == nil || s.addedListid != s.listid {
+		s.added = make(map[string]bool)
+		s.addedListid = s.listid
+	}
+	var key strings.Builder
+	fmt.Fprintf(&key, "%p %d", ns, nsx.brPos)
+	for _, r := range nsx.registers.ranges {
+		fmt.Fprintf(&key, " %d:%d", r.Start, r.End)
+	}
+	if s.added[key.String()] {
+		return true
+	}
+	s.added[key.String()] = true
+	return false
+}
+
+// A zero-width state (a split, or an assertion) starts and ends its
+// registers when it is added to a list, as it never matches an object.
+func (s *executorT[T]) zeroWidthRegisters(pos int, ns *nfaStateT[T], regs *registersT) {
+	for _, rn := range ns.startsRegisters {
+		// The matching character starts this register,
+		// unless it was already set (due to a glob or
+		// repetition looping back to this split)
+		if regs.ranges[rn-1].Start == -1 {
+			dlog.Printf("addstate setting start reg #%d = pos %d", rn, pos)
+			regs.ranges[rn-1].Start = pos + 1
+		}
+	}
+	for _, rn := range ns.endsRegisters {
+		dlog.Printf("addstate setting end reg #%d = pos %d", rn, pos)
+		// The end paren is this pos, but we record pos+1
+		// to be more like Go slices
+		// check that start was seen first; it won't be
+		// in "*" glob
+		if regs.ranges[rn-1].Start != -1 {
+			regs.ranges[rn-1].End = pos + 1
+		}
+	}
+}
+
+// Does the assertion's NFA match the input, starting at pos? Unlike
+// _match, this doesn't look for the best match, only whether there is
+// one, and an empty match is fine. The registers set by the assertion's
+// NFA are thrown away, but it starts with the registers of the state
+// being added, for its backreferences. A lookbehind reads the objects
+// before pos, backwards, and can look before the place where the regex
+// began matching.
+func (s *executorT[T]) assertionMatches(ns *nfaStateT[T], pos int, regs *registersT) bool {
+	a := ns.assertion
+	var sub executorT[T]
+	sub.initialize(s.regex, &a.matchstate)
+	sub.input = s.input
+	if ns.meta == mtLookbehind {
+		// "^" is the beginning of the input
+		sub.reverse = true
+		sub.prePos = -1
+	} else {
+		// "^" still means the place where the whole regex began matching
+		sub.prePos = s.prePos
+	}
+
+	sub.listid++
+	xstart := sub.exState(a.nfa)
+	clist := sub.addstate(pos-1, nil, &nfaRegStateT[T]{xstart, regs.Copy(), false, 0})
+	var nlist []*nfaRegStateT[T]
+	for i := pos; ; {
+		if matched, _, _ := sub.ismatch(i, clist); matched {
+			return true
+		}
+		if sub.reverse {
+			if i == 0 || len(clist) == 0 {
+				return false
+			}
+			// Reading input[i-1] moves the boundary to i-1
+			nlist = sub.step(i-2, clist, s.input[i-1], nlist)
+			i--
+		} else {
+			if i >= len(s.input) {
+				matched, _ := sub.matchesEnd(i, clist)
+				return matched
+			}
+			if len(clist) == 0 {
+				return false
+			}
+			nlist = sub.step(i, clist, s.input[i], nlist)
+			i++
+		}
+		clist, nlist = nlist, clist
+	}
+}
+
 /*
  * Step the NFA from the states in clist
  * past the character ch,
@@ -364,7 +549,7 @@ func (s *executorT[T]) step(pos int, clist []*nfaRegStateT[T], ch T, nlist []*nf
 				dlog.Printf("Negation -> %v", matches)
 			}
 		case ntIdentity:
-			matches = ns.iObj == ch
+			matches = ns.iObj == ns.normalizer.apply(ch)
 			dlog.Printf("Identity %s: %v", ns.cName, matches)
 			// Are we testing for non-memberhood?
 			if ns.negation {
@@ -376,7 +561,7 @@ func (s *executorT[T]) step(pos int, clist []*nfaRegStateT[T], ch T, nlist []*nf
 			case mtAny:
 				matches = true
 
-			case mtAssertBegin:
+			case mtAssertBegin, mtLookahead, mtLookbehind:
 				panic("should not reach")
 
 			case mtAssertEnd:
@@ -391,6 +576,31 @@ func (s *executorT[T]) step(pos int, clist []*nfaRegStateT[T], ch T, nlist []*nf
 		case ntDynClass:
 			matches = ns.dynClass.Matches(ch)
 			dlog.Printf("Matches dynClass %s: %v", ns.cName, matches)
+		case ntIdentitySet:
+			matches = ns.iSet.has(ch)
+			dlog.Printf("Identity set %s: %v", ns.cName, matches)
+			// Are we testing for non-memberhood?
+			if ns.negation {
+				matches = !matches
+				dlog.Printf("Negation -> %v", matches)
+			}
+		case ntBackref:
+			// Compare with the next captured object. Running
+			// backwards, the captured objects are compared
+			// from the last one.
+			r := regs.ranges[ns.regNum-1]
+			normalizer := s.regex.normalizer
+			if s.reverse {
+				matches = normalizer.apply(ch) == normalizer.apply(s.input[r.End-1-xnsr.brPos])
+			} else {
+				matches = normalizer.apply(ch) == normalizer.apply(s.input[r.Start+xnsr.brPos])
+			}
+			dlog.Printf("Backref %d #%d: %v", ns.regNum, xnsr.brPos, matches)
+			if matches && xnsr.brPos+1 < r.End-r.Start {
+				// There are more captured objects to compare
+				nlist = s.addstate(pos, nlist, &nfaRegStateT[T]{xns, regs.Copy(), xnsr.deferred, xnsr.brPos + 1})
+				continue
+			}
 		}
 
 		if matches {
@@ -408,15 +618,16 @@ func (s *executorT[T]) step(pos int, clist []*nfaRegStateT[T], ch T, nlist []*nf
 				regs.ranges[rn-1].End = pos
 			}
 			dlog.Printf("This nfa's registers: %v\n", regs.ranges)
-			nlist = s.addstate(pos, nlist, &nfaRegStateT[T]{xns.out, regs.Copy()})
+			nlist = s.addstate(pos, nlist, &nfaRegStateT[T]{xns.out, regs.Copy(), xnsr.deferred, 0})
 		}
 	}
 	return nlist
 }
 
-// Check whether state list contains a match.
-func (s *executorT[T]) ismatch(pos int, l []*nfaRegStateT[T]) (bool, *nfaRegStateT[T]) {
-	for _, nsr := range l {
+// Check whether state list contains a match. The first (highest priority)
+// match is returned, along with its index in the list.
+func (s *executorT[T]) ismatch(pos int, l []*nfaRegStateT[T]) (bool, int, *nfaRegStateT[T]) {
+	for i, nsr := range l {
 		ns := nsr.root
 		regs := nsr.registers
 		if ns == s.matchstate {
@@ -425,10 +636,10 @@ func (s *executorT[T]) ismatch(pos int, l []*nfaRegStateT[T]) (bool, *nfaRegStat
 				regs.ranges[rn-1].End = pos
 			}
 			dlog.Printf("matched; registers: %+v", nsr.registers.ranges)
-			return true, nsr
+			return true, i, nsr
 		}
 	}
-	return false, nil
+	return false, -1, nil
 }
 
 // Check if we match "$"
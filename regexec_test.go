@@ -3,7 +3,12 @@
 package objregexp
 
 import (
-	. "gopkg.in/check.v1"
+	"math/rand"
+	"strings"
+
+	"github.com/gilramir/objregexp/syntax"
+
+	. "github.com/gilramir/objregexp/internal/check"
 )
 
 // Test Match vs FullMatch
@@ -543,3 +548,647 @@ func (s *MySuite) TestRegexpMatchEmpty08(c *C) {
 	c.Check(m.Group(1).Start, Equals, -1)
 	c.Check(m.Group(1).End, Equals, -1)
 }
+
+// Test {m,n} repetition
+func (s *MySuite) TestRegexpRepeat01(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	text := "[:digit:]{3,4}"
+	re, err := compiler.Compile(text)
+	c.Assert(err, IsNil)
+
+	input := []rune{'1', '2'}
+	m := re.Match(input)
+	c.Check(m.Success, Equals, false)
+
+	input = []rune{'1', '2', '3'}
+	m = re.FullMatch(input)
+	c.Check(m.Success, Equals, true)
+
+	input = []rune{'1', '2', '3', '4', '5'}
+	m = re.Match(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range.Start, Equals, 0)
+	c.Check(m.Range.End, Equals, 4)
+
+	m = re.FullMatch(input)
+	c.Check(m.Success, Equals, false)
+}
+
+// Test {m} and {m,} repetition
+func (s *MySuite) TestRegexpRepeat02(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	re, err := compiler.Compile("[:digit:]{2}")
+	c.Assert(err, IsNil)
+
+	input := []rune{'1', '2', '3'}
+	m := re.Match(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range.End, Equals, 2)
+
+	re, err = compiler.Compile("[:vowel:] [:digit:]{2,}")
+	c.Assert(err, IsNil)
+
+	input = []rune{'A', '1'}
+	m = re.Match(input)
+	c.Check(m.Success, Equals, false)
+
+	input = []rune{'A', '1', '2', '3', 'E'}
+	m = re.Match(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range.End, Equals, 4)
+
+	re, err = compiler.Compile("[:vowel:]{0} [:digit:]")
+	c.Assert(err, IsNil)
+
+	input = []rune{'1'}
+	m = re.FullMatch(input)
+	c.Check(m.Success, Equals, true)
+}
+
+// A repeated group covers all of its repetitions, like "*" does
+func (s *MySuite) TestRegexpRepeat03(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	re, err := compiler.Compile("([:vowel:][:digit:]){1,2} ([:digit:])")
+	c.Assert(err, IsNil)
+
+	input := []rune{'A', '1', 'E', '2', '3'}
+	m := re.FullMatch(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Group(1), Equals, Range{0, 4})
+	c.Check(m.Group(2), Equals, Range{4, 5})
+
+	input = []rune{'A', '1', '3'}
+	m = re.FullMatch(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Group(1), Equals, Range{0, 2})
+	c.Check(m.Group(2), Equals, Range{2, 3})
+
+	// The group starts with a split node in each copy
+	re, err = compiler.Compile("([:vowel:]?){2} [:digit:]")
+	c.Assert(err, IsNil)
+
+	input = []rune{'A', 'E', '1'}
+	m = re.FullMatch(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Group(1), Equals, Range{0, 2})
+}
+
+// Repetitions that would make the NFA too large are rejected
+func (s *MySuite) TestRegexpRepeat04(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	_, err := compiler.Compile("([:digit:]{1000}){1000}")
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals,
+		"The repetition at pos 17 expands to more than 100000 states")
+}
+
+// A glob of a zero repetition is a loop which consumes nothing,
+// which Search and the executor must not go around forever
+func (s *MySuite) TestRegexpRepeat05(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddIdentity("a", 'a')
+	compiler.Finalize()
+
+	input := []rune{'b', 'a', 'a'}
+
+	re, err := compiler.Compile("([:a:]{0})* [:a:]")
+	c.Assert(err, IsNil)
+	m := re.Search(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{1, 2})
+	c.Check(m.HasGroup(1), Equals, false)
+
+	re, err = compiler.Compile("(?:[:a:]{0})+ [:a:]")
+	c.Assert(err, IsNil)
+	c.Check(re.Search(input).Range, Equals, Range{1, 2})
+	c.Check(re.FullMatch([]rune{'a'}).Success, Equals, true)
+
+	re, err = compiler.Compile("([:a:]) (?:[:a:]{0})* \\1")
+	c.Assert(err, IsNil)
+	c.Check(re.Search(input).Range, Equals, Range{1, 3})
+}
+
+// Lazy globs match as few objects as they can
+func (s *MySuite) TestRegexpLazy01(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(UpperClass)
+	compiler.AddClass(ConsonantClass)
+	compiler.Finalize()
+
+	input := []rune{'A', 'B', 'C', 'D'}
+
+	re, err := compiler.Compile("[:upper:]* [:consonant:]")
+	c.Assert(err, IsNil)
+	m := re.Match(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{0, 4})
+
+	re, err = compiler.Compile("[:upper:]*? [:consonant:]")
+	c.Assert(err, IsNil)
+	m = re.Match(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{0, 2})
+
+	re, err = compiler.Compile("[:upper:]+? [:consonant:]")
+	c.Assert(err, IsNil)
+	m = re.Match(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{0, 2})
+
+	re, err = compiler.Compile("[:upper:]{2,}? [:consonant:]")
+	c.Assert(err, IsNil)
+	m = re.Match(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{0, 3})
+
+	// A lazy glob still has to let the whole regex match
+	m = re.FullMatch(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{0, 4})
+}
+
+// The capture groups reflect the lazy choice
+func (s *MySuite) TestRegexpLazy02(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.Finalize()
+
+	input := []rune{'A', 'E', 'I', 'O'}
+
+	re, err := compiler.Compile("([:vowel:]*?) ([:vowel:]*)")
+	c.Assert(err, IsNil)
+	m := re.FullMatch(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.HasGroup(1), Equals, false)
+	c.Check(m.Group(2), Equals, Range{0, 4})
+
+	re, err = compiler.Compile("([:vowel:]+?) ([:vowel:]*)")
+	c.Assert(err, IsNil)
+	m = re.FullMatch(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Group(1), Equals, Range{0, 1})
+	c.Check(m.Group(2), Equals, Range{1, 4})
+
+	re, err = compiler.Compile("([:vowel:]??) ([:vowel:]*)")
+	c.Assert(err, IsNil)
+	m = re.FullMatch(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.HasGroup(1), Equals, false)
+	c.Check(m.Group(2), Equals, Range{0, 4})
+
+	re, err = compiler.Compile("([:vowel:]{2,3}?) ([:vowel:]*)")
+	c.Assert(err, IsNil)
+	m = re.FullMatch(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Group(1), Equals, Range{0, 2})
+	c.Check(m.Group(2), Equals, Range{2, 4})
+}
+
+// A lazy glob which can match nothing prefers to, even at the start
+// of the input
+func (s *MySuite) TestRegexpLazy03(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	input := []rune{'A', 'E', 'I'}
+	for _, text := range []string{"[:vowel:]??", "[:vowel:]*?", "[:vowel:]{0,2}?",
+		"([:vowel:]??)", "[:vowel:]*? [:digit:]*"} {
+		re, err := compiler.Compile(text)
+		c.Assert(err, IsNil)
+		m := re.Match(input)
+		c.Check(m.Success, Equals, true, Commentf(text))
+		c.Check(m.Range, Equals, Range{0, 0}, Commentf(text))
+
+		m = re.MatchAt(input, 1)
+		c.Check(m.Success, Equals, true, Commentf(text))
+		c.Check(m.Range, Equals, Range{1, 1}, Commentf(text))
+	}
+
+	// The glob still has to let the whole regex match
+	re := compiler.MustCompile("[:vowel:]*?")
+	m := re.FullMatch(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{0, 3})
+
+	// It matches more, if that's what the rest of the regex needs
+	re = compiler.MustCompile("[:vowel:]{0,2}? [:digit:]")
+	m = re.Match([]rune{'A', '1'})
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{0, 2})
+}
+
+// A group followed by a glob ends where the group does, not
+// where the glob's repetitions do
+func (s *MySuite) TestRegexpGroupBeforeGlob01(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	re, err := compiler.Compile("([:vowel:]) ([:digit:])+")
+	c.Assert(err, IsNil)
+
+	input := []rune{'A', '1', '2'}
+	m := re.FullMatch(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Group(1), Equals, Range{0, 1})
+	c.Check(m.Group(2), Equals, Range{1, 3})
+}
+
+// Non-capturing groups
+func (s *MySuite) TestRegexpNonCapturing01(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(ConsonantClass)
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	re, err := compiler.Compile("(?:[:vowel:][:consonant:])+ ([:digit:])")
+	c.Assert(err, IsNil)
+	c.Check(re.numRegisters, Equals, 1)
+
+	input := []rune{'A', 'B', 'E', 'C', '1'}
+	m := re.FullMatch(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Group(1), Equals, Range{4, 5})
+	c.Check(m.HasGroup(2), Equals, false)
+
+	input = []rune{'A', 'B', 'E', '1'}
+	m = re.FullMatch(input)
+	c.Check(m.Success, Equals, false)
+}
+
+// Positive lookahead
+func (s *MySuite) TestRegexpLookahead01(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	re, err := compiler.Compile("([:vowel:]+) (?=[:digit:] [:digit:])")
+	c.Assert(err, IsNil)
+
+	// The lookahead doesn't consume the digits
+	input := []rune{'A', 'E', '1', '2'}
+	m := re.Match(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{0, 2})
+	c.Check(m.Group(1), Equals, Range{0, 2})
+
+	input = []rune{'A', 'E', '1'}
+	m = re.Match(input)
+	c.Check(m.Success, Equals, false)
+
+	// At the end of the input
+	re, err = compiler.Compile("[:vowel:] (?=$)")
+	c.Assert(err, IsNil)
+
+	input = []rune{'A'}
+	m = re.FullMatch(input)
+	c.Check(m.Success, Equals, true)
+
+	input = []rune{'A', 'E'}
+	m = re.Search(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{1, 2})
+}
+
+// Negative lookahead
+func (s *MySuite) TestRegexpLookahead02(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	re, err := compiler.Compile("[:vowel:] (?![:digit:]) .")
+	c.Assert(err, IsNil)
+
+	input := []rune{'A', '1'}
+	m := re.Match(input)
+	c.Check(m.Success, Equals, false)
+
+	input = []rune{'A', 'E', '1'}
+	m = re.Match(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{0, 2})
+
+	// Nothing follows, so the negative lookahead succeeds
+	re, err = compiler.Compile("[:vowel:] (?![:digit:])")
+	c.Assert(err, IsNil)
+
+	input = []rune{'A'}
+	m = re.FullMatch(input)
+	c.Check(m.Success, Equals, true)
+}
+
+// A capture group inside a lookahead doesn't set the outer registers
+func (s *MySuite) TestRegexpLookahead03(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	re, err := compiler.Compile("([:vowel:]) (?=([:digit:]))")
+	c.Assert(err, IsNil)
+
+	input := []rune{'A', '1'}
+	m := re.Match(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Group(1), Equals, Range{0, 1})
+	c.Check(m.HasGroup(2), Equals, false)
+}
+
+func (s *MySuite) TestRegexpLookbehind01(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	// The lookbehind doesn't consume the vowels, and its length
+	// isn't fixed
+	re, err := compiler.Compile("(?<=[:vowel:]+ [:digit:]) ([:digit:])")
+	c.Assert(err, IsNil)
+
+	input := []rune{'A', 'E', '1', '2'}
+	m := re.Search(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{3, 4})
+	c.Check(m.Group(1), Equals, Range{3, 4})
+
+	input = []rune{'1', '2'}
+	m = re.Search(input)
+	c.Check(m.Success, Equals, false)
+
+	// The lookbehind can look before the start of the match
+	input = []rune{'E', '1', '2', '3'}
+	m = re.MatchAt(input, 2)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{2, 3})
+
+	m = re.MatchAt(input, 3)
+	c.Check(m.Success, Equals, false)
+}
+
+// Negative lookbehind, and anchors inside a lookbehind
+func (s *MySuite) TestRegexpLookbehind02(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	re, err := compiler.Compile("(?<![:vowel:]) [:digit:]")
+	c.Assert(err, IsNil)
+
+	input := []rune{'A', '1', '2'}
+	m := re.Search(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{2, 3})
+
+	// Nothing precedes, so the negative lookbehind succeeds
+	m = re.Match(input[1:])
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{0, 1})
+
+	// "^" inside a lookbehind is the beginning of the input
+	re, err = compiler.Compile("(?<=^[:vowel:]) [:digit:]")
+	c.Assert(err, IsNil)
+
+	input = []rune{'A', '1', 'E', '2'}
+	m = re.Search(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{1, 2})
+
+	m = re.SearchAt(input, 2)
+	c.Check(m.Success, Equals, false)
+
+	// "$" inside a lookbehind is the end of the input
+	re, err = compiler.Compile("[:digit:] (?<=[:digit:]$)")
+	c.Assert(err, IsNil)
+
+	m = re.Search(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{3, 4})
+}
+
+func (s *MySuite) TestRegexpBackref01(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	re, err := compiler.Compile("([:vowel:]+) [:digit:] \\1")
+	c.Assert(err, IsNil)
+
+	input := []rune{'A', 'E', '1', 'A', 'E'}
+	m := re.FullMatch(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Group(1), Equals, Range{0, 2})
+
+	input = []rune{'A', 'E', '1', 'A'}
+	m = re.FullMatch(input)
+	c.Check(m.Success, Equals, false)
+
+	input = []rune{'A', 'E', '1', 'E', 'A'}
+	m = re.FullMatch(input)
+	c.Check(m.Success, Equals, false)
+
+	// The glob only takes as many vowels as the
+	// backreference can repeat
+	re, err = compiler.Compile("([:vowel:]+) \\1")
+	c.Assert(err, IsNil)
+
+	input = []rune{'A', 'A', 'A'}
+	m = re.Match(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{0, 2})
+	c.Check(m.Group(1), Equals, Range{0, 1})
+
+	// A group which matched nothing
+	re, err = compiler.Compile("([:vowel:]*) [:digit:] \\1")
+	c.Assert(err, IsNil)
+
+	input = []rune{'1'}
+	m = re.FullMatch(input)
+	c.Check(m.Success, Equals, true)
+}
+
+// Named backreferences, and backreferences inside assertions
+func (s *MySuite) TestRegexpBackref02(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	re, err := compiler.Compile("(?P<v>[:vowel:]) [:digit:] (?P=v)")
+	c.Assert(err, IsNil)
+
+	input := []rune{'1', 'A', '2', 'A'}
+	m := re.Search(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{1, 4})
+
+	input = []rune{'1', 'A', '2', 'E'}
+	m = re.Search(input)
+	c.Check(m.Success, Equals, false)
+
+	_, err = compiler.Compile("(?P=v) (?P<v>[:vowel:])")
+	c.Check(err, ErrorMatches, "The backreference at pos 0 refers to group 'v', which doesn't exist or is still open")
+
+	re, err = compiler.Compile("([:vowel:]) (?=[:digit:] \\1)")
+	c.Assert(err, IsNil)
+
+	input = []rune{'A', '1', 'A'}
+	m = re.Match(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{0, 1})
+
+	input = []rune{'A', '1', 'E'}
+	m = re.Match(input)
+	c.Check(m.Success, Equals, false)
+
+	re, err = compiler.Compile("([:vowel:]+) [:digit:] (?<=\\1 [:digit:])")
+	c.Assert(err, IsNil)
+
+	input = []rune{'A', 'E', '1'}
+	m = re.Match(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{0, 3})
+}
+
+func (s *MySuite) TestRegexpComment01(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	re, err := compiler.Compile(`
+		# Save one or more vowels
+		([:vowel:]+)
+		# followed by a digit
+		[:digit:](?#, only one)
+	`)
+	c.Assert(err, IsNil)
+
+	input := []rune{'A', 'E', '1', '2'}
+	m := re.Match(input)
+	c.Check(m.Success, Equals, true)
+	c.Check(m.Range, Equals, Range{0, 3})
+	c.Check(m.Group(1), Equals, Range{0, 2})
+}
+
+// Simplifying the syntax tree doesn't change the results, or the
+// capture groups, of random patterns on random inputs
+func (s *MySuite) TestRegexpSimplify01(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	for _, r := range "abcy" {
+		compiler.AddIdentity(string(r), r)
+	}
+	compiler.Finalize()
+	factory := newNfaFactory[rune](&compiler)
+
+	patterns := []string{
+		"[!:a:]*? [:c:] | [!:a:]*? .",
+		"[:a:]? ([:a:]) | [:a:]? [:b:]*",
+		"[!:a:]* ([:c:]) | [!:a:]* [:b:]?",
+		"(?:.?)+ [:y:] . | (?:.?)+ ([!:a:])",
+		"[:a:] ([:b:]) | [:a:] ([:c:]*)",
+		"[:a:] | [:a:] [:b:]",
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for len(patterns) < 500 {
+		patterns = append(patterns, randomPattern(rnd, 3))
+	}
+
+	for _, text := range patterns {
+		tree, err := syntax.Parse(text)
+		c.Assert(err, IsNil, Commentf("%q", text))
+		plain, err := factory.treeRegexp(tree, tree.String())
+		c.Assert(err, IsNil, Commentf("%q", text))
+		simpleTree := tree.Simplify()
+		simplified, err := factory.treeRegexp(simpleTree, tree.String())
+		c.Assert(err, IsNil, Commentf("%q simplified to %q", text, simpleTree))
+
+		for i := 0; i < 8; i++ {
+			input := make([]rune, rnd.Intn(5))
+			for j := range input {
+				input[j] = rune("abcy"[rnd.Intn(4)])
+			}
+			comment := Commentf("%q simplified to %q, on %q", text, simpleTree, string(input))
+			for start := 0; start <= len(input); start++ {
+				c.Check(simplified.MatchAt(input, start), DeepEquals, plain.MatchAt(input, start), comment)
+				c.Check(simplified.FullMatchAt(input, start), DeepEquals, plain.FullMatchAt(input, start), comment)
+			}
+			c.Check(simplified.Search(input), DeepEquals, plain.Search(input), comment)
+		}
+		if c.Failed() {
+			return
+		}
+	}
+}
+
+// Make a random pattern, with items nested up to depth deep
+func randomPattern(rnd *rand.Rand, depth int) string {
+	atoms := []string{"[:a:]", "[!:a:]", "[:b:]", "[:c:]", "[:y:]", ".", "[:a: || :b:]"}
+	quantifiers := []string{"*", "+", "?", "*?", "+?", "??", "{0,2}", "{1,2}?"}
+	if depth == 0 || rnd.Intn(3) == 0 {
+		return atoms[rnd.Intn(len(atoms))]
+	}
+	switch rnd.Intn(6) {
+	case 0, 1:
+		items := make([]string, 1+rnd.Intn(3))
+		for i := range items {
+			items[i] = randomPattern(rnd, depth-1)
+		}
+		return strings.Join(items, " ")
+	case 2:
+		// Choices which are likely to start the same way
+		prefix := randomPattern(rnd, depth-1)
+		choices := make([]string, 2+rnd.Intn(2))
+		for i := range choices {
+			choices[i] = prefix
+			if rnd.Intn(4) != 0 {
+				choices[i] += " " + randomPattern(rnd, depth-1)
+			}
+		}
+		return "(?:" + strings.Join(choices, " | ") + ")"
+	case 3:
+		return "(" + randomPattern(rnd, depth-1) + ")"
+	default:
+		return "(?:" + randomPattern(rnd, depth-1) + ")" + quantifiers[rnd.Intn(len(quantifiers))]
+	}
+}
@@ -9,6 +9,15 @@ import (
 
 // The compiled regex.
 type Regexp[T comparable] struct {
+	// The regex as it was given to Compile
+	source string
+
+	// The regex in canonical form
+	canonical string
+
+	// The class and identity names used in the regex
+	names []string
+
 	// the root node of the stack; where the parse begins
 	nfa *nfaStateT[T]
 
@@ -21,25 +30,66 @@ type Regexp[T comparable] struct {
 	// Maps regNames to regNums
 	regNameMap map[string]int
 
+	// If there are backreferences, threads with different registers
+	// can't be merged while executing.
+	hasBackrefs bool
+
+	// The normalizer of the Compiler, which backreferences compare
+	// objects with
+	normalizer *normalizerT[T]
+
 	// Does the regexp need to start with some specific object?
 	// This helps the Search() method.
 	initialObj *nfaStateT[T]
 }
 
+// Returns the regex in canonical form, as syntax.Format would.
+// For a Regexp made by Build, this is the regex that would compile
+// to the same Regexp, unless the Pattern has a name in it which can't
+// be written in a regex string, like one with a ':' or ']' in it; see
+// CheckName.
+func (s *Regexp[T]) String() string {
+	return s.canonical
+}
+
+// Returns the regex as it was given to Compile. For a Regexp made
+// by Build, this is the same as String().
+func (s *Regexp[T]) Source() string {
+	return s.source
+}
+
+// Returns the class and identity names that the regex uses, in the
+// order that they first appear in it, each one once. Names from
+// imports are given as they were written in the regex.
+func (s *Regexp[T]) Names() []string {
+	return append([]string(nil), s.names...)
+}
+
 // Does this regex only match at the beginning of the input?
 // That is, must ^ be satisified always for this regexp?
 func (s *Regexp[T]) onlyMatchesAtBeginning() bool {
 	//	log.Printf("nfa: %s", s.nfa.Repr())
-	return s.onlyMatchesAtBeginningRecursive(s.nfa)
+	return s.onlyMatchesAtBeginningRecursive(s.nfa, make(map[*nfaStateT[T]]bool))
 }
 
-func (s *Regexp[T]) onlyMatchesAtBeginningRecursive(nfa *nfaStateT[T]) bool {
+// seen holds the split nodes already walked. A glob of something which
+// matches nothing, like "(?:[:a:]{0})*", is a loop of split nodes.
+func (s *Regexp[T]) onlyMatchesAtBeginningRecursive(nfa *nfaStateT[T], seen map[*nfaStateT[T]]bool) bool {
+	// A split node may have only one way out
+	if nfa == nil {
+		return true
+	}
 	switch nfa.c {
 	case ntMeta:
 		return nfa.meta == mtAssertBegin
 	case ntSplit:
-		return s.onlyMatchesAtBeginningRecursive(nfa.out) &&
-			s.onlyMatchesAtBeginningRecursive(nfa.out1)
+		// Going around the loop again leads nowhere new
+		if seen[nfa] {
+			return true
+		}
+		seen[nfa] = true
+		return s.onlyMatchesAtBeginningRecursive(nfa.out, seen) &&
+			s.onlyMatchesAtBeginningRecursive(nfa.out1, seen)
 	default:
 		return false
 	}
@@ -47,24 +97,33 @@ func (s *Regexp[T]) onlyMatchesAtBeginningRecursive(nfa *nfaStateT[T]) bool {
 
 // Does this regex only match at the beginning of the input?
 // If an nfaStateT is returned, it will be an ntClass,
-// ntIdentity, or ntDynClass. Otherwise, nil is returned.
+// ntIdentity, ntDynClass, or ntIdentitySet. Otherwise, nil is returned.
 func (s *Regexp[T]) mustStartWith() *nfaStateT[T] {
 	switch s.nfa.c {
 	case ntClass:
 		return &nfaStateT[T]{
-			c:      s.nfa.c,
-			oClass: s.nfa.oClass,
+			c:        s.nfa.c,
+			oClass:   s.nfa.oClass,
+			negation: s.nfa.negation,
 		}
 	case ntIdentity:
 		return &nfaStateT[T]{
-			c:    s.nfa.c,
-			iObj: s.nfa.iObj,
+			c:          s.nfa.c,
+			iObj:       s.nfa.iObj,
+			normalizer: s.nfa.normalizer,
+			negation:   s.nfa.negation,
 		}
 	case ntDynClass:
 		return &nfaStateT[T]{
 			c:        s.nfa.c,
 			dynClass: s.nfa.dynClass,
 		}
+	case ntIdentitySet:
+		return &nfaStateT[T]{
+			c:        s.nfa.c,
+			iSet:     s.nfa.iSet,
+			negation: s.nfa.negation,
+		}
 	default:
 		return nil
 	}
@@ -257,11 +316,14 @@ func (s *Regexp[T]) SearchAt(input []T, start int) Match {
 			var iMatch bool
 			switch s.initialObj.c {
 			case ntClass:
-				iMatch = s.initialObj.oClass.Matches(ch)
+				iMatch = s.initialObj.oClass.Matches(ch) != s.initialObj.negation
 			case ntIdentity:
-				iMatch = s.initialObj.iObj == ch
+				iMatch = (s.initialObj.iObj == s.initialObj.normalizer.apply(ch)) !=
+					s.initialObj.negation
 			case ntDynClass:
 				iMatch = s.initialObj.dynClass.Matches(ch)
+			case ntIdentitySet:
+				iMatch = s.initialObj.iSet.has(ch) != s.initialObj.negation
 			}
 			if iMatch {
 				m := s.MatchAt(input, i)
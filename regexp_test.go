@@ -3,7 +3,7 @@
 package objregexp
 
 import (
-	. "gopkg.in/check.v1"
+	. "github.com/gilramir/objregexp/internal/check"
 )
 
 func (s *MySuite) TestOnlyMatchesAtBeginning(c *C) {
@@ -63,8 +63,15 @@ func (s *MySuite) TestMustStartWith(c *C) {
 	c.Assert(nfa, NotNil)
 	c.Check(nfa.c, Equals, ntDynClass)
 
+	// The choices are merged into a single test
 	re, err = compiler.Compile("[:e:] | [:vowel:]")
 	c.Assert(err, IsNil)
+	nfa = re.mustStartWith()
+	c.Assert(nfa, NotNil)
+	c.Check(nfa.c, Equals, ntDynClass)
+
+	re, err = compiler.Compile("[:e:] | [:vowel:] [:e:]")
+	c.Assert(err, IsNil)
 	c.Check(re.mustStartWith(), IsNil)
 
 	re, err = compiler.Compile("[:e:]?")
@@ -86,6 +93,18 @@ func (s *MySuite) TestMustStartWith(c *C) {
 	re, err = compiler.Compile("^[:e:]*")
 	c.Assert(err, IsNil)
 	c.Check(re.mustStartWith(), IsNil)
+
+	// Negations are kept, so that Search tries the right objects
+	re, err = compiler.Compile("[!:vowel:]")
+	c.Assert(err, IsNil)
+	nfa = re.mustStartWith()
+	c.Assert(nfa, NotNil)
+	c.Check(nfa.negation, Equals, true)
+	c.Check(re.Search([]rune("ab")).Range, Equals, Range{1, 2})
+
+	re, err = compiler.Compile("[!:e:]")
+	c.Assert(err, IsNil)
+	c.Check(re.Search([]rune("eb")).Range, Equals, Range{1, 2})
 }
 
 func (s *MySuite) TestCompileNestedGroupNames(c *C) {
@@ -111,3 +130,26 @@ func (s *MySuite) TestCompileDuplicateGroupNames(c *C) {
 	_, err := compiler.Compile(rt)
 	c.Assert(err, NotNil)
 }
+
+func (s *MySuite) TestRegexpString01(c *C) {
+	var compiler Compiler[rune]
+	compiler.Initialize()
+	compiler.AddClass(VowelClass)
+	compiler.AddClass(DigitClass)
+	compiler.Finalize()
+
+	text := "[:vowel:]+  ( [:digit:]|[:vowel: && !:digit:] )  # comment"
+	re, err := compiler.Compile(text)
+	c.Assert(err, IsNil)
+	c.Check(re.Source(), Equals, text)
+	c.Check(re.String(), Equals, "[:vowel:]+ ([:digit:] | [:vowel: && !:digit:])")
+
+	re = compiler.MustBuild(Seq(Plus(ClassRef("vowel")),
+		Alt(ClassRef("digit"), Not(Or(ClassRef("vowel"), ClassRef("digit"))))))
+	c.Check(re.String(), Equals, "[:vowel:]+ (?:[:digit:] | [!(:vowel: || :digit:)])")
+	c.Check(re.Source(), Equals, re.String())
+
+	// The canonical form compiles to the same regex
+	_, err = compiler.Compile(re.String())
+	c.Check(err, IsNil)
+}
@@ -0,0 +1,429 @@
+// Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
+
+package syntax
+
+// Convert infix to postfix using Shuting yard algorithm:
+// https://en.wikipedia.org/wiki/Shunting_yard_algorithm
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+type dcTokenTypeT string
+
+// The token types
+const (
+	dctClass       dcTokenTypeT = "C" // :alpha:
+	dctNoOp                     = "?" // Short-circuit jump target for && and ||
+	dctNot                      = "!"
+	dctLParen                   = "("
+	dctRParen                   = ")"
+	dctJumpIfFalse              = "F" // short-circuit for &&
+	dctJumpIfTrue               = "T" // short-circuit for ||
+)
+
+const (
+	notPrecedence = 1
+	andPrecedence = 2
+	orPrecedence  = 3
+)
+
+type dcTokenT struct {
+	ttype dcTokenTypeT
+
+	// position in the dynamic class string; used for reporting syntax
+	// errors to the user.
+	pos int
+
+	// For dctClass, name is the name of the class
+	name string
+
+	// where to jump to for JumpIfFalse and JumpIfTrue
+	// if on an NoOp operand, and jmpTarget is set, the next insns
+	// is the target
+	jmpTarget int
+
+	precedence int
+}
+
+// ParseClass parses the text between the brackets of a dynamic class,
+// like ":lower: && !:vowel:", and returns its syntax tree.
+func ParseClass(text string) (*ClassExpr, error) {
+	var parser dcParserStateT
+	parser.Initialize(text)
+	tokens, err := parser.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	// The tokens are in postfix order. The jumps are only for
+	// short-circuiting, so the NoOp's, which are their targets, are
+	// what combine the operands.
+	stack := make([]*ClassExpr, 0, len(tokens))
+	for _, tok := range tokens {
+		var expr *ClassExpr
+		switch tok.ttype {
+		case dctClass:
+			// tok.pos is after the ':'
+			expr = &ClassExpr{Op: ClassName, Name: tok.name, Pos: tok.pos - 1}
+
+		case dctNot:
+			if len(stack) < 1 {
+				return nil, NewError(text, tok.pos-1, ErrMissingOperand,
+					fmt.Sprintf("'!' at pos %d has nothing to negate", tok.pos))
+			}
+			expr = &ClassExpr{Op: ClassNot, Sub: []*ClassExpr{stack[len(stack)-1]},
+				Pos: tok.pos - 1}
+			stack = stack[:len(stack)-1]
+
+		case dctNoOp:
+			op, opText := ClassAnd, "&&"
+			if tok.precedence == orPrecedence {
+				op, opText = ClassOr, "||"
+			}
+			if len(stack) < 2 {
+				return nil, NewError(text, tok.pos-2, ErrMissingOperand,
+					fmt.Sprintf("%s at pos %d is missing an operand", opText, tok.pos-1))
+			}
+			expr = &ClassExpr{Op: op, Pos: stack[len(stack)-2].Pos}
+			for _, sub := range stack[len(stack)-2:] {
+				if sub.Op == op {
+					expr.Sub = append(expr.Sub, sub.Sub...)
+				} else {
+					expr.Sub = append(expr.Sub, sub)
+				}
+			}
+			stack = stack[:len(stack)-2]
+
+		case dctJumpIfFalse, dctJumpIfTrue:
+			continue
+
+		default:
+			panic(fmt.Sprintf("Unexpected token type %v", tok.ttype))
+		}
+		stack = append(stack, expr)
+	}
+
+	if len(stack) != 1 {
+		return nil, NewError(text, 0, ErrMissingOperand,
+			fmt.Sprintf("The class string '%s' must have exactly 1 expression", text))
+	}
+	return stack[0], nil
+}
+
+// Move the positions in the expression, from being in the text of a
+// dynamic class, to being in the regex, where the text starts at byteOffset.
+func (s *ClassExpr) rebase(byteOffset int) {
+	s.Pos += byteOffset
+	for _, sub := range s.Sub {
+		sub.rebase(byteOffset)
+	}
+}
+
+type dcParserStateT struct {
+	input runeBufferT
+	stack stackT[dcTokenT]
+
+	nextJumpTarget int
+
+	// The tokens parsed so far, in postfix order
+	tokens []dcTokenT
+
+	// The error which stopped the parse
+	err error
+}
+
+func (s *dcParserStateT) Initialize(input string) {
+	s.input.Initialize(input)
+	s.input.runeErrorCb = s.emitRuneError
+	s.tokens = make([]dcTokenT, 0, len(input)/2+1)
+	s.stack = NewStack[dcTokenT]()
+}
+
+// Parse the class text, returning the tokens in postfix order
+func (s *dcParserStateT) parse() ([]dcTokenT, error) {
+	s.parseTokens()
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.tokens, nil
+}
+
+func (s *dcParserStateT) parseTokens() {
+	allowClass := true
+	allowAndOr := false
+
+	for {
+		// Get the next rune
+		ok, r, eof := s.input.getNextRune()
+		if !ok {
+			return
+		}
+		if eof {
+			break
+		}
+
+		switch r {
+		case ' ', '\t', '\n':
+			continue
+
+		case '(':
+			s.parseLParen()
+			allowClass = true
+			allowAndOr = false
+
+		case '|':
+			if allowAndOr {
+				s.parsePipe()
+				allowClass = true
+			} else {
+				s.emitErrorf(ErrMissingOperand, s.input.pos-1,
+					"|| is not allowed at pos %d", s.input.pos)
+				return
+			}
+
+		case '&':
+			if allowAndOr {
+				s.parseAmpersand()
+				allowClass = true
+			} else {
+				s.emitErrorf(ErrMissingOperand, s.input.pos-1,
+					"&& is not allowed at pos %d", s.input.pos)
+				return
+			}
+
+		case ')':
+			s.parseRParen()
+			allowClass = false
+			allowAndOr = true
+
+		case ':':
+			if allowClass {
+				s.parseColon()
+				allowClass = false
+				allowAndOr = true
+			} else {
+				s.emitErrorf(ErrUnexpectedRune, s.input.pos-1,
+					"Class name not allowed at pos %d", s.input.pos)
+				return
+			}
+
+		case '!':
+			s.parseBang()
+			allowClass = true
+			allowAndOr = false
+
+		default:
+			s.emitErrorf(ErrUnexpectedRune, s.input.pos-utf8.RuneLen(r),
+				"Syntax error at pos %d starting with '%c'", s.input.pos, r)
+			return
+		}
+
+		if s.err != nil {
+			return
+		}
+	}
+
+	for s.stack.Size() > 0 {
+		tok := s.stack.Top()
+		if tok.ttype == dctLParen {
+			s.emitErrorf(ErrUnbalancedParen, tok.pos-1,
+				"Unbalanced left paren starting at pos %d", tok.pos)
+			return
+		} else {
+			s.emit(tok)
+			s.stack.Pop()
+		}
+	}
+}
+
+func (s *dcParserStateT) parseLParen() {
+	s.stack.Push(dcTokenT{
+		ttype: dctLParen,
+		pos:   s.input.pos,
+	})
+}
+func (s *dcParserStateT) parseRParen() {
+	for {
+		if s.stack.Size() == 0 {
+			s.emitErrorf(ErrUnbalancedParen, s.input.pos-1,
+				"Unbalanced right paren at pos %d", s.input.pos)
+			return
+		}
+		tok := s.stack.Top()
+		if tok.ttype == dctLParen {
+			break
+		}
+		s.emit(tok)
+		s.stack.Pop()
+	}
+	// the tok on the top of a stack was a LParen; pop & discard it
+	s.stack.Pop()
+}
+
+func (s *dcParserStateT) parsePipe() {
+	startPos := s.input.pos
+	ok, r, eof := s.input.getNextRune()
+	if eof {
+		s.emitUnexpectedEOF()
+		return
+	}
+	if !ok {
+		return
+	}
+	if r != '|' {
+		s.emitErrorf(ErrUnexpectedRune, s.input.pos-utf8.RuneLen(r),
+			"Expected 2 |'s at pos %d", startPos)
+		return
+	}
+
+	s.nextJumpTarget++
+	jmpTarget := s.nextJumpTarget
+
+	for s.stack.Size() > 0 {
+		tok := s.stack.Top()
+		if tok.ttype == dctLParen || tok.precedence >= orPrecedence {
+			break
+		} else {
+			s.emit(tok)
+			s.stack.Pop()
+		}
+	}
+	s.emit(dcTokenT{
+		ttype:     dctJumpIfTrue,
+		pos:       startPos,
+		jmpTarget: jmpTarget,
+	})
+
+	s.stack.Push(dcTokenT{
+		ttype:      dctNoOp,
+		pos:        s.input.pos,
+		jmpTarget:  jmpTarget,
+		precedence: orPrecedence,
+	})
+}
+
+func (s *dcParserStateT) parseAmpersand() {
+	startPos := s.input.pos
+	ok, r, eof := s.input.getNextRune()
+	if eof {
+		s.emitUnexpectedEOF()
+		return
+	}
+	if !ok {
+		return
+	}
+	if r != '&' {
+		s.emitErrorf(ErrUnexpectedRune, s.input.pos-utf8.RuneLen(r),
+			"Expected 2 &'s at pos %d", startPos)
+		return
+	}
+
+	s.nextJumpTarget++
+	jmpTarget := s.nextJumpTarget
+
+	for s.stack.Size() > 0 {
+		tok := s.stack.Top()
+		if tok.ttype == dctLParen || tok.precedence >= andPrecedence {
+			break
+		} else {
+			s.emit(tok)
+			s.stack.Pop()
+		}
+	}
+	s.emit(dcTokenT{
+		ttype:     dctJumpIfFalse,
+		pos:       startPos,
+		jmpTarget: jmpTarget,
+	})
+
+	s.stack.Push(dcTokenT{
+		ttype:      dctNoOp,
+		pos:        s.input.pos,
+		jmpTarget:  jmpTarget,
+		precedence: andPrecedence,
+	})
+}
+func (s *dcParserStateT) parseBang() {
+	for s.stack.Size() > 0 {
+		tok := s.stack.Top()
+		if tok.ttype == dctLParen || tok.precedence >= notPrecedence {
+			break
+		} else {
+			s.emit(tok)
+			s.stack.Pop()
+		}
+	}
+
+	s.stack.Push(dcTokenT{
+		ttype:      dctNot,
+		pos:        s.input.pos,
+		precedence: notPrecedence,
+	})
+}
+func (s *dcParserStateT) parseColon() {
+	// Read rune names until the ending colon
+	nameRunes := make([]rune, 0, 20)
+
+	classPos := s.input.pos
+	for {
+		ok, r, eof := s.input.getNextRune()
+		if eof {
+			s.emitUnexpectedEOF()
+			return
+		}
+		if !ok {
+			return
+		}
+
+		// Allow anything except non-visible code point glyphs.
+		// But do allow spaces
+		if !unicode.IsGraphic(r) && r != ' ' {
+			s.emitErrorf(ErrInvalidName, s.input.pos-utf8.RuneLen(r),
+				"The class name starting at pos %d has a non-graphic Unicode code point in it",
+				classPos)
+			return
+		}
+
+		if r == ':' {
+			break
+		}
+		nameRunes = append(nameRunes, r)
+	}
+
+	// The parens of an argument must be balanced
+	if off, code, what := checkArg(string(nameRunes)); off != -1 {
+		s.emitErrorf(code, classPos+off,
+			"The class name at pos %d has %s at pos %d", classPos, what, classPos+off)
+		return
+	}
+
+	s.emit(dcTokenT{
+		ttype: dctClass,
+		pos:   classPos,
+		name:  string(nameRunes),
+	})
+
+}
+
+func (s *dcParserStateT) emit(token dcTokenT) {
+	s.tokens = append(s.tokens, token)
+}
+
+// Record an Error for the problem at byte offset pos, which
+// stops the parse. Only the first one is kept.
+func (s *dcParserStateT) emitErrorf(code ErrorCode, pos int, f string, args ...any) {
+	if s.err == nil {
+		s.err = NewError(s.input.input, pos, code, fmt.Sprintf(f, args...))
+	}
+}
+
+func (s *dcParserStateT) emitRuneError() {
+	s.emitErrorf(ErrInvalidUTF8, s.input.pos,
+		"Bytes starting at position %d aren't valid UTF-8", s.input.pos)
+}
+func (s *dcParserStateT) emitUnexpectedEOF() {
+	s.emitErrorf(ErrUnexpectedEOF, len(s.input.input), "Unexpected end of string")
+}
@@ -0,0 +1,173 @@
+// Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
+
+package syntax
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// The kind of problem an Error reports
+type ErrorCode int
+
+const (
+	// An unitialized ErrorCode will be 0 but with no enum name
+	ErrUnexpectedRune    ErrorCode = iota + 1 // a rune that can't be there
+	ErrUnexpectedEOF                          // the regex ended too soon
+	ErrInvalidUTF8                            // bytes that aren't UTF-8
+	ErrMissingOperand                         // an operator with nothing to operate on
+	ErrUnbalancedParen                        // a paren without its partner
+	ErrInvalidGroup                           // a bad "(?" or group name
+	ErrInvalidRepetition                      // a bad {m,n}
+	ErrInvalidBackref                         // a backreference to a bad group
+	ErrNestedAssertion                        // an assertion inside a lookbehind
+	ErrUnknownName                            // an unregistered class or identity
+	ErrInvalidName                            // a class name with bad runes in it
+	ErrInvalidClass                           // a bracket that isn't [:name:]
+	ErrAmbiguousName                          // a name in more than one import
+	ErrInvalidArgument                        // an argument that a class factory rejected
+)
+
+func (s ErrorCode) String() string {
+	switch s {
+	case ErrUnexpectedRune:
+		return "unexpected rune"
+	case ErrUnexpectedEOF:
+		return "unexpected end of string"
+	case ErrInvalidUTF8:
+		return "invalid UTF-8"
+	case ErrMissingOperand:
+		return "missing operand"
+	case ErrUnbalancedParen:
+		return "unbalanced paren"
+	case ErrInvalidGroup:
+		return "invalid group"
+	case ErrInvalidRepetition:
+		return "invalid repetition"
+	case ErrInvalidBackref:
+		return "invalid backreference"
+	case ErrNestedAssertion:
+		return "nested assertion"
+	case ErrUnknownName:
+		return "unknown name"
+	case ErrInvalidName:
+		return "invalid name"
+	case ErrInvalidClass:
+		return "invalid class"
+	case ErrAmbiguousName:
+		return "ambiguous name"
+	case ErrInvalidArgument:
+		return "invalid argument"
+	default:
+		return "unknown error code"
+	}
+}
+
+// An Error is returned by Parse when the regex can't be parsed.
+// The offsets locate the problem in the regex.
+type Error struct {
+	// The regex being compiled
+	Pattern string
+
+	// Where the problem is, counted in runes and in bytes
+	Offset     int
+	ByteOffset int
+
+	Code ErrorCode
+	Msg  string
+}
+
+// NewError returns an Error for the problem at byteOffset in pattern.
+// It is for tools which find problems of their own in a syntax tree,
+// like unknown class names.
+func NewError(pattern string, byteOffset int, code ErrorCode, msg string) *Error {
+	return &Error{
+		Pattern:    pattern,
+		Offset:     utf8.RuneCountInString(pattern[:byteOffset]),
+		ByteOffset: byteOffset,
+		Code:       code,
+		Msg:        msg,
+	}
+}
+
+func (s *Error) Error() string {
+	return s.Msg
+}
+
+// Caret returns the line of the regex which has the problem, and under
+// it, a caret pointing at the problem:
+//
+//	[:vowel:] [:vowl:]
+//	          ^
+func (s *Error) Caret() string {
+	lineStart := strings.LastIndexByte(s.Pattern[:s.ByteOffset], '\n') + 1
+	lineEnd := strings.IndexByte(s.Pattern[s.ByteOffset:], '\n')
+	if lineEnd == -1 {
+		lineEnd = len(s.Pattern)
+	} else {
+		lineEnd += s.ByteOffset
+	}
+
+	// Tabs are kept, so that the caret lines up with the regex
+	var caret strings.Builder
+	for _, r := range s.Pattern[lineStart:s.ByteOffset] {
+		if r == '\t' {
+			caret.WriteRune('\t')
+		} else {
+			caret.WriteRune(' ')
+		}
+	}
+	caret.WriteRune('^')
+	return s.Pattern[lineStart:lineEnd] + "\n" + caret.String()
+}
+
+// rebase moves an error in a string inside of a regex, like the dynamic
+// class string, to be an error in the regex, which starts at byteOffset.
+func (s *Error) rebase(pattern string, byteOffset int) {
+	s.Pattern = pattern
+	s.ByteOffset += byteOffset
+	s.Offset = utf8.RuneCountInString(pattern[:s.ByteOffset])
+}
+
+// Errors is returned by Compile when the regex has more than one
+// problem. They are in the order of their offsets.
+type Errors []*Error
+
+func (s Errors) Error() string {
+	switch len(s) {
+	case 0:
+		return "no errors"
+	case 1:
+		return s[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", s[0].Error(), len(s)-1)
+}
+
+// Unwrap returns the errors, so that errors.Is and errors.As look in
+// each of them.
+func (s Errors) Unwrap() []error {
+	errs := make([]error, len(s))
+	for i, e := range s {
+		errs[i] = e
+	}
+	return errs
+}
+
+// Return nil for no errors, the SyntaxError if there is only one,
+// or Errors, sorted by offset, for more than one.
+func JoinErrors(errs []*Error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	sorted := make(Errors, len(errs))
+	copy(sorted, errs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ByteOffset < sorted[j].ByteOffset
+	})
+	return sorted
+}
@@ -0,0 +1,208 @@
+// Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
+
+package syntax
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Format parses the regex and returns it in canonical form, with the
+// comments removed, and with the same spacing and bracket syntax
+// everywhere. It is like gofmt, but for regexes.
+func Format(pattern string) (string, error) {
+	re, err := Parse(pattern)
+	if err != nil {
+		return "", err
+	}
+	return re.String(), nil
+}
+
+// String returns the regex in canonical form. Items in a sequence are
+// separated by a space, and alternate choices by " | ". Non-capturing
+// groups are only used where they are needed.
+func (s *Regexp) String() string {
+	var b strings.Builder
+	s.write(&b)
+	return b.String()
+}
+
+func (s *Regexp) write(b *strings.Builder) {
+	switch s.Op {
+	case OpClass:
+		if s.Negate {
+			b.WriteString("[!:")
+		} else {
+			b.WriteString("[:")
+		}
+		b.WriteString(s.Name)
+		b.WriteString(":]")
+
+	case OpClassExpr:
+		b.WriteString("[")
+		s.Class.write(b, ClassOr)
+		b.WriteString("]")
+
+	case OpAnyObject:
+		b.WriteString(".")
+
+	case OpBeginText:
+		b.WriteString("^")
+
+	case OpEndText:
+		b.WriteString("$")
+
+	case OpConcat:
+		for i, sub := range s.Sub {
+			if i > 0 {
+				b.WriteString(" ")
+			}
+			// An alternation would take in its neighbors
+			sub.writeGrouped(b, sub.Op == OpAlternate)
+		}
+
+	case OpAlternate:
+		for i, sub := range s.Sub {
+			if i > 0 {
+				b.WriteString(" | ")
+			}
+			sub.write(b)
+		}
+
+	case OpStar, OpPlus, OpQuest, OpRepeat:
+		// A quantifier can only follow a single item. A quantified
+		// item is grouped, so that "x??" isn't read as a lazy "x?"
+		sub := s.Sub[0]
+		switch sub.Op {
+		case OpConcat, OpAlternate, OpStar, OpPlus, OpQuest, OpRepeat:
+			sub.writeGrouped(b, true)
+		default:
+			sub.write(b)
+		}
+		switch s.Op {
+		case OpStar:
+			b.WriteString("*")
+		case OpPlus:
+			b.WriteString("+")
+		case OpQuest:
+			b.WriteString("?")
+		case OpRepeat:
+			b.WriteString("{")
+			b.WriteString(strconv.Itoa(s.Min))
+			if s.Max != s.Min {
+				b.WriteString(",")
+				if s.Max != -1 {
+					b.WriteString(strconv.Itoa(s.Max))
+				}
+			}
+			b.WriteString("}")
+		}
+		if s.Lazy {
+			b.WriteString("?")
+		}
+
+	case OpCapture:
+		if s.Name != "" {
+			b.WriteString("(?P<")
+			b.WriteString(s.Name)
+			b.WriteString(">")
+		} else {
+			b.WriteString("(")
+		}
+		s.Sub[0].write(b)
+		b.WriteString(")")
+
+	case OpLookahead:
+		if s.Negate {
+			b.WriteString("(?!")
+		} else {
+			b.WriteString("(?=")
+		}
+		s.Sub[0].write(b)
+		b.WriteString(")")
+
+	case OpLookbehind:
+		if s.Negate {
+			b.WriteString("(?<!")
+		} else {
+			b.WriteString("(?<=")
+		}
+		s.Sub[0].write(b)
+		b.WriteString(")")
+
+	case OpBackref:
+		if s.Name != "" {
+			b.WriteString("(?P=")
+			b.WriteString(s.Name)
+			b.WriteString(")")
+		} else {
+			b.WriteString("\\")
+			b.WriteString(strconv.Itoa(s.Cap))
+		}
+	}
+}
+
+// Write the node, inside a non-capturing group if grouped is true
+func (s *Regexp) writeGrouped(b *strings.Builder, grouped bool) {
+	if grouped {
+		b.WriteString("(?:")
+	}
+	s.write(b)
+	if grouped {
+		b.WriteString(")")
+	}
+}
+
+// String returns the expression in canonical form, without the
+// brackets around it. "&&" and "||" have a space on each side, and
+// parens are only used where they are needed.
+func (s *ClassExpr) String() string {
+	var b strings.Builder
+	s.write(&b, ClassOr)
+	return b.String()
+}
+
+// Write the expression. outer is the operator that the expression is
+// an operand of; ClassAnd binds more tightly than ClassOr, and ClassNot
+// binds more tightly than both.
+func (s *ClassExpr) write(b *strings.Builder, outer ClassOp) {
+	switch s.Op {
+	case ClassName:
+		b.WriteString(":")
+		b.WriteString(s.Name)
+		b.WriteString(":")
+
+	case ClassNot:
+		sub := s.Sub[0]
+		if sub.Op == ClassNot {
+			// "!!" would look like a malformed class
+			sub.Sub[0].write(b, outer)
+			return
+		}
+		b.WriteString("!")
+		sub.write(b, ClassNot)
+
+	case ClassAnd, ClassOr:
+		if len(s.Sub) == 1 {
+			s.Sub[0].write(b, outer)
+			return
+		}
+		op := " && "
+		if s.Op == ClassOr {
+			op = " || "
+		}
+		parens := outer < s.Op
+		if parens {
+			b.WriteString("(")
+		}
+		for i, sub := range s.Sub {
+			if i > 0 {
+				b.WriteString(op)
+			}
+			sub.write(b, s.Op)
+		}
+		if parens {
+			b.WriteString(")")
+		}
+	}
+}
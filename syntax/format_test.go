@@ -0,0 +1,68 @@
+// Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
+
+package syntax
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestFormat01(c *C) {
+	tests := []struct {
+		pattern  string
+		expected string
+	}{
+		{"[:a:][:b:]", "[:a:] [:b:]"},
+		{"[ ! :a: ]", "[!:a:]"},
+		{"[:a:]|[:b:]   [:c:]", "[:a:] | [:b:] [:c:]"},
+		{"[:a:] ([:b:]|[:c:])* . ^ $", "[:a:] ([:b:] | [:c:])* . ^ $"},
+		{"[:a:] (?:[:b:]|[:c:]) [:d:]", "[:a:] (?:[:b:] | [:c:]) [:d:]"},
+		{"(?:[:a:] [:b:])", "[:a:] [:b:]"},
+		{"(?:[:a:] [:b:])+?", "(?:[:a:] [:b:])+?"},
+		{"(?:[:a:]?)?", "(?:[:a:]?)?"},
+		{"[:a:]{2} [:b:]{ 2 , } [:c:]{2,3}?", "[:a:]{2} [:b:]{2,} [:c:]{2,3}?"},
+		{"(?P<x> [:a:] ) (?P=x) \\1", "(?P<x>[:a:]) (?P=x) \\1"},
+		{"(?= [:a:]) (?![:b:]) (?<=[:c:]) (?<![:d:])", "(?=[:a:]) (?![:b:]) (?<=[:c:]) (?<![:d:])"},
+		{"[:a:] # comment\n (?#another)", "[:a:]"},
+		{"[:a:&&:b:||:c:]", "[:a: && :b: || :c:]"},
+		{"[:a: && (:b: || :c:)]", "[:a: && (:b: || :c:)]"},
+		{"[(:a: && :b:) || :c:]", "[:a: && :b: || :c:]"},
+		{"[!(:a: || :b:) && !:c:]", "[!(:a: || :b:) && !:c:]"},
+		{"[:a: || (:b: || :c:)]", "[:a: || :b: || :c:]"},
+		{"[:lower x: && :ü:]", "[:lower x: && :ü:]"},
+	}
+
+	for _, t := range tests {
+		text, err := Format(t.pattern)
+		c.Assert(err, IsNil, Commentf("%q", t.pattern))
+		c.Check(text, Equals, t.expected, Commentf("%q", t.pattern))
+
+		// The canonical form is canonical
+		again, err := Format(text)
+		c.Assert(err, IsNil, Commentf("%q", text))
+		c.Check(again, Equals, text)
+	}
+
+	_, err := Format("[:a:] (")
+	c.Check(err, NotNil)
+}
+
+// Trees which aren't made by Parse still format as regexes which parse
+func (s *MySuite) TestFormat02(c *C) {
+	a := &Regexp{Op: OpClass, Name: "a"}
+	b := &Regexp{Op: OpClass, Name: "b"}
+
+	re := &Regexp{Op: OpConcat, Sub: []*Regexp{
+		a,
+		&Regexp{Op: OpAlternate, Sub: []*Regexp{a, b}},
+		&Regexp{Op: OpQuest, Sub: []*Regexp{&Regexp{Op: OpStar, Sub: []*Regexp{b}}}},
+	}}
+	c.Check(re.String(), Equals, "[:a:] (?:[:a:] | [:b:]) (?:[:b:]*)?")
+
+	name := &ClassExpr{Op: ClassName, Name: "a"}
+	expr := &ClassExpr{Op: ClassNot, Sub: []*ClassExpr{
+		&ClassExpr{Op: ClassNot, Sub: []*ClassExpr{name}}}}
+	c.Check(expr.String(), Equals, ":a:")
+
+	expr = &ClassExpr{Op: ClassAnd, Sub: []*ClassExpr{name}}
+	c.Check(expr.String(), Equals, ":a:")
+}
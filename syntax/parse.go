@@ -0,0 +1,973 @@
+// Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
+
+package syntax
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The debug logger for this package. By default, output is discarded.
+var dlog *log.Logger
+
+func init() {
+	dlog = log.New(ioutil.Discard, "[DEBUG] ", log.Ldate|log.Lmicroseconds|log.Lshortfile)
+}
+
+// Change the debug logger object in this package
+func SetDebugLogger(logger *log.Logger) {
+	dlog = logger
+}
+
+type tokenTypeT string
+
+// The token types
+const (
+	tClass        tokenTypeT = "C" // [:alpha:]
+	tDynClass                = "D" // [:alpha:]
+	tConcat                  = "." // concatenate, for internal postfix notation
+	tAlternate               = "|" // alternate choices
+	tGlobStar                = "*" // *
+	tGlobPlus                = "+" // +
+	tGlobQuestion            = "?" // ?
+	tRepeat                  = "{" // {m}, {m,}, {m,n}
+	tLookahead               = "=" // (?=...) or (?!...)
+	tLookbehind              = "<" // (?<=...) or (?<!...)
+	tBackref                 = "B" // \1 or (?P=name)
+	tAny                     = "A" // .
+	tEndRegister             = ")" // Record info about the close paren
+	tAssertBegin             = "^"
+	tAssertEnd               = "$"
+)
+
+type tokenT struct {
+	ttype tokenTypeT
+	// position in the regex string; used for reporting syntax
+	// errors to the user.
+	pos int
+
+	// For tClass, name is the name of the class
+	name string
+
+	// negation is only used For tClass, tLookahead and tLookbehind
+	negation bool
+
+	// For the globs and tRepeat, prefer as few repetitions as possible
+	lazy bool
+
+	// For tEndReg, holds the register number, and if present, regName.
+	// For tBackref, holds either the register number or the regName.
+	regNum  int
+	regName string
+
+	// For tRepeat, the minimum and maximum number of repetitions.
+	// repeatMax is -1 if there is no maximum.
+	repeatMin int
+	repeatMax int
+}
+
+func makeTokensString(tokens []tokenT) string {
+	text := ""
+	for _, t := range tokens {
+		text += string(t.ttype)
+	}
+	return text
+}
+
+func (s *tokenT) Repr() string {
+	if s.ttype == tRepeat {
+		return fmt.Sprintf("<tokenT %s min:%d max:%d lazy:%t pos:%d>",
+			s.ttype, s.repeatMin, s.repeatMax, s.lazy, s.pos)
+	}
+	return fmt.Sprintf("<tokenT %s name:%s neg:%t pos:%d reg#:%d>",
+		s.ttype, s.name, s.negation, s.pos, s.regNum)
+}
+
+func printTokens(tokens []tokenT) {
+	for i, t := range tokens {
+		dlog.Printf("#%d. %s", i, t.Repr())
+	}
+}
+
+// Parse parses a regex and returns its syntax tree. If the regex has
+// problems, the error is an *Error, or Errors if there is more than one.
+func Parse(pattern string) (*Regexp, error) {
+	re, errs := ParseAll(pattern)
+	if len(errs) > 0 {
+		return nil, JoinErrors(errs)
+	}
+	return re, nil
+}
+
+// ParseAll is like Parse, but it returns every problem found, and as
+// much of the syntax tree as could be parsed. A problem that the parser
+// recovered from, like a malformed class, leaves an OpAnyObject in its
+// place. If a problem stopped the parse, the tree only has what was
+// parsed before it. The tree is nil only if nothing could be parsed.
+func ParseAll(pattern string) (*Regexp, []*Error) {
+	tokens, errs, _ := parseRegexAll(pattern)
+	var b treeBuilderT
+	b.Initialize(pattern)
+	for _, token := range tokens {
+		b.add(token)
+	}
+	return b.tree(), append(errs, b.errs...)
+}
+
+func parseRegex(input string) ([]tokenT, error) {
+	tokens, errs, _ := parseRegexAll(input)
+	if len(errs) > 0 {
+		return nil, JoinErrors(errs)
+	}
+	return tokens, nil
+}
+
+// Parse the regex, returning the tokens and every error found.
+// If an error stopped the parse, complete is false, and the tokens
+// can't be used; otherwise the parse recovered from every error.
+// Returns tokens, errs, complete
+func parseRegexAll(input string) ([]tokenT, []*Error, bool) {
+	var pstate reParserStateT
+	pstate.Initialize(input)
+
+	// The parse keeps going after an error it can recover from,
+	// and stops after one it can't.
+	pstate.parse()
+	if len(pstate.errs) > 0 {
+		return pstate.tokens, pstate.errs, !pstate.emittedError
+	}
+	return pstate.tokens, nil, true
+}
+
+type reParserStateT struct {
+	input runeBufferT
+
+	// The tokens parsed so far, in postfix order
+	tokens []tokenT
+
+	// The errors found so far
+	errs []*Error
+
+	groupNumsAllocated int
+
+	// Was an error emitted, which stopped the parse?
+	emittedError bool
+
+	// How many lookbehinds are we inside of?
+	lookbehindDepth int
+
+	// The number of binary choices (alternations) that need to still be emitted
+	nbin int
+
+	// The byte offset of the last '|', for reporting a choice after
+	// it which is missing
+	pipePos int
+
+	// Number of atoms emitted that still need to be concatened with
+	natom int
+
+	// stack of pre-paren states, so that when a paren is closed,
+	// the previous state can be restored
+	p []backc
+
+	// The pos in p where the next stack entry can be placed.
+	j int
+}
+
+type backc struct {
+	nbin      int
+	natom     int
+	groupKind groupKindT
+	// the position of the open paren
+	groupPos int
+	// groupNum is 0 for a non-capturing group
+	groupNum  int
+	groupName string
+}
+
+// The kinds of things that can be inside a pair of parens
+type groupKindT int
+
+const (
+	gkCapture       groupKindT = iota // (...) or (?P<name>...)
+	gkNonCapture                      // (?:...)
+	gkLookahead                       // (?=...)
+	gkNegLookahead                    // (?!...)
+	gkLookbehind                      // (?<=...)
+	gkNegLookbehind                   // (?<!...)
+	gkBackref                         // (?P=name), which is not a group
+	gkComment                         // (?#...), which is not a group
+)
+
+func (s groupKindT) isAssertion() bool {
+	return s == gkLookahead || s == gkNegLookahead ||
+		s == gkLookbehind || s == gkNegLookbehind
+}
+
+func (s *reParserStateT) Initialize(input string) {
+	s.input.Initialize(input)
+	s.input.runeErrorCb = s.emitRuneError
+	// Most regexes have a token for every few bytes
+	s.tokens = make([]tokenT, 0, len(input)/2+1)
+	s.p = make([]backc, 0)
+	s.ensure_stack_space()
+}
+
+func (s *reParserStateT) ensure_stack_space() {
+	if len(s.p) <= s.j+1 {
+		extra := s.j - len(s.p) + 1
+		s.p = append(s.p, make([]backc, extra)...)
+	}
+}
+
+// Parse the whole regex, appending the tokens to s.tokens, in
+// postfix order, and the errors to s.errs
+func (s *reParserStateT) parse() {
+	for {
+		// Get the next rune
+		ok, r, eof := s.input.getNextRune()
+		if !ok {
+			return
+		}
+		if eof {
+			break
+		}
+
+		switch r {
+		case ' ', '\t', '\n':
+			continue
+
+		case '#':
+			if !s.parseComment() {
+				return
+			}
+
+		case '(':
+			ok, eof = s.parseLParen()
+			if !ok {
+				return
+			}
+			if eof {
+				break
+			}
+
+		case '|':
+			s.parsePipe()
+
+		case ')':
+			s.parseRParen()
+
+		case '*', '+', '?':
+			s.parseGlob(r)
+
+		case '{':
+			s.parseLBrace()
+
+		case '\\':
+			s.parseBackslash()
+
+		case '[':
+			s.parseLBracket()
+
+		case '.':
+			s.parseSimpleToken(tAny)
+
+		case '^':
+			s.parseSimpleToken(tAssertBegin)
+
+		case '$':
+			s.parseSimpleToken(tAssertEnd)
+
+		default:
+			s.emitErrorf(ErrUnexpectedRune, s.input.pos-utf8.RuneLen(r),
+				"Syntax error at pos %d starting with '%c'", s.input.pos, r)
+			return
+		}
+
+		if s.emittedError {
+			return
+		}
+	}
+
+	// If the stack of saved contexts is not empty, we have an error
+	if s.j != 0 {
+		s.emitUnexpectedEOF()
+		return
+	}
+	if !s.checkChoiceAfterPipe() {
+		return
+	}
+
+	for s.natom--; s.natom > 0; s.natom-- {
+		s.emitConcatenation()
+	}
+
+	for ; s.nbin > 0; s.nbin-- {
+		s.emitAlternation()
+	}
+}
+
+// returns ok, eof
+func (s *reParserStateT) parseLParen() (bool, bool) {
+	startPos := s.input.pos - 1
+
+	// Then do the regular LParen logic
+	if s.natom > 1 {
+		s.natom--
+		s.emitConcatenation()
+	}
+
+	// is there a "?P<name>", "?:", "?=", "?!", "?<=" or "?<!"
+	// after the lparen?
+	kind := gkCapture
+	var name string
+	ok, r, eof := s.input.peekNextRune()
+	if !ok {
+		return s.input.consumeNextRune()
+	}
+	if eof {
+		s.emitUnexpectedEOF()
+		return false, false
+	}
+	if r == '?' {
+		s.input.consumeNextRune()
+		ok, kind, name, eof = s.parseLParenQuestion()
+		if !ok || eof {
+			return ok, eof
+		}
+	}
+
+	// A named backreference is an atom, not a group
+	if kind == gkBackref {
+		s.emitBackref(startPos, 0, name)
+		return true, false
+	}
+
+	// A comment is skipped, up to and including its ')'
+	if kind == gkComment {
+		return s.parseInlineComment(), false
+	}
+
+	// A lookbehind's NFA runs backwards, so it can't hold an
+	// assertion, which would need to run forwards.
+	if kind.isAssertion() && s.lookbehindDepth > 0 {
+		s.emitErrorf(ErrNestedAssertion, startPos,
+			"The assertion at pos %d cannot be inside a lookbehind",
+			s.input.pos)
+		return false, false
+	}
+	if kind == gkLookbehind || kind == gkNegLookbehind {
+		s.lookbehindDepth++
+	}
+
+	// Only capturing groups are given a register number
+	groupNum := 0
+	if kind == gkCapture {
+		s.groupNumsAllocated++
+		groupNum = s.groupNumsAllocated
+	}
+
+	s.p[s.j].nbin = s.nbin
+	s.p[s.j].natom = s.natom
+	s.p[s.j].groupKind = kind
+	s.p[s.j].groupNum = groupNum
+	s.p[s.j].groupName = name
+	s.p[s.j].groupPos = startPos
+
+	//dlog.Printf("pstack %d => %+v", s.j, s.p[s.j])
+	s.j++
+	s.ensure_stack_space()
+	s.nbin = 0
+	s.natom = 0
+
+	return true, false
+}
+
+const (
+	lpqExpectP    = 1 // "P", ":", "=", "!", "<" or "#"
+	lpqExpectLab  = 2 // Left angled bracket
+	lpqExpectRab  = 3 // Right angled bracket
+	lpqExpectBang = 4 // "=" or "!" after "<"
+	lpqExpectRp   = 5 // Right paren after "P=name"
+)
+
+// Parse "P<name>", "P=name)", ":", "=", "!", "<=", "<!" or "#" after the "(?"
+// returns ok, groupKind, groupName, eof
+func (s *reParserStateT) parseLParenQuestion() (bool, groupKindT, string, bool) {
+	var state int = lpqExpectP
+
+	groupRunes := make([]rune, 0, 10)
+
+	nameStartPos := 0
+inputLoop:
+	for {
+		// Get the next rune
+		ok, r, eof := s.input.getNextRune()
+		if !ok || eof {
+			return ok, gkCapture, "", eof
+		}
+
+		switch state {
+		case lpqExpectP:
+			switch r {
+			case 'P':
+				state = lpqExpectLab
+				continue
+			case ':':
+				return true, gkNonCapture, "", false
+			case '=':
+				return true, gkLookahead, "", false
+			case '!':
+				return true, gkNegLookahead, "", false
+			case '<':
+				state = lpqExpectBang
+				continue
+			case '#':
+				return true, gkComment, "", false
+			default:
+				s.emitErrorf(ErrInvalidGroup, s.input.pos-utf8.RuneLen(r),
+					"Expected 'P', ':', '=', '!', '<' or '#' after '(?' at pos %d", s.input.pos)
+				return false, gkCapture, "", false
+			}
+		case lpqExpectBang:
+			switch r {
+			case '=':
+				return true, gkLookbehind, "", false
+			case '!':
+				return true, gkNegLookbehind, "", false
+			default:
+				s.emitErrorf(ErrInvalidGroup, s.input.pos-utf8.RuneLen(r),
+					"Expected '=' or '!' after '(?<' at pos %d", s.input.pos)
+				return false, gkCapture, "", false
+			}
+		case lpqExpectLab:
+			if r == '<' {
+				state = lpqExpectRab
+				nameStartPos = s.input.pos
+				continue
+			} else if r == '=' {
+				state = lpqExpectRp
+				nameStartPos = s.input.pos
+				continue
+			} else {
+				s.emitErrorf(ErrInvalidGroup, s.input.pos-utf8.RuneLen(r),
+					"Expected '<' or '=' after '(?P' at pos %d", s.input.pos)
+				return false, gkCapture, "", false
+			}
+		case lpqExpectRp:
+			if r == ')' {
+				if len(groupRunes) == 0 {
+					s.emitErrorf(ErrInvalidGroup, nameStartPos,
+						"The backreference name at pos %d is empty", nameStartPos)
+					return false, gkCapture, "", false
+				}
+				s.checkGroupName(groupRunes, nameStartPos)
+				return true, gkBackref, string(groupRunes), false
+			} else {
+				groupRunes = append(groupRunes, r)
+				continue
+			}
+		case lpqExpectRab:
+			if r == '>' {
+				break inputLoop
+			} else {
+				groupRunes = append(groupRunes, r)
+				continue
+			}
+		}
+	}
+
+	if len(groupRunes) == 0 {
+		// Keep going, as an unnamed group
+		s.noteErrorf(ErrInvalidGroup, nameStartPos,
+			"The capture group name at pos %d is empty", nameStartPos)
+		return true, gkCapture, "", false
+	}
+	s.checkGroupName(groupRunes, nameStartPos)
+	return true, gkCapture, string(groupRunes), false
+}
+
+// A group name can't have spaces or non-graphic code points in it.
+// This only notes the error; the parse can keep going.
+func (s *reParserStateT) checkGroupName(name []rune, pos int) {
+	for _, r := range name {
+		if !unicode.IsGraphic(r) || unicode.IsSpace(r) {
+			s.noteErrorf(ErrInvalidGroup, pos,
+				"The group name '%s' at pos %d can't have spaces or non-graphic code points",
+				string(name), pos)
+			return
+		}
+	}
+}
+
+// Skip a "#" comment, up to the end of the line. Returns ok
+func (s *reParserStateT) parseComment() bool {
+	for {
+		ok, r, eof := s.input.getNextRune()
+		if !ok {
+			return false
+		}
+		if eof || r == '\n' {
+			return true
+		}
+	}
+}
+
+// Skip a "(?#" comment, up to the close paren. Returns ok
+func (s *reParserStateT) parseInlineComment() bool {
+	for {
+		ok, r, eof := s.input.getNextRune()
+		if !ok {
+			return false
+		}
+		if eof {
+			s.emitUnexpectedEOF()
+			return false
+		}
+		if r == ')' {
+			return true
+		}
+	}
+}
+
+func (s *reParserStateT) parsePipe() {
+	if s.natom == 0 {
+		s.emitErrorf(ErrMissingOperand, s.input.pos-1,
+			"'|' at pos %d is not allowed", s.input.pos)
+		return
+	}
+	for s.natom--; s.natom > 0; s.natom-- {
+		s.emitConcatenation()
+	}
+	s.pipePos = s.input.pos - 1
+	s.nbin++
+}
+
+// A '|' must have a choice after it, before the end of the regex or
+// of its group. Returns false if it doesn't, after emitting an error.
+func (s *reParserStateT) checkChoiceAfterPipe() bool {
+	if s.nbin > 0 && s.natom == 0 {
+		s.emitErrorf(ErrMissingOperand, s.pipePos,
+			"'|' at pos %d has nothing after it", s.pipePos+1)
+		return false
+	}
+	return true
+}
+
+func (s *reParserStateT) parseRParen() {
+	// First emit the regular RParen stuff
+	if s.j != 0 && !s.checkChoiceAfterPipe() {
+		return
+	}
+	if s.j == 0 || s.natom == 0 {
+		s.emitErrorf(ErrUnbalancedParen, s.input.pos-1,
+			"Close paren ')' at pos %d doesn't follow an opening paren.", s.input.pos)
+		return
+	}
+
+	dlog.Printf(") => atoms %d nbins %d", s.natom, s.nbin)
+
+	for s.natom--; s.natom > 0; s.natom-- {
+		s.emitConcatenation()
+	}
+
+	for ; s.nbin > 0; s.nbin-- {
+		s.emitAlternation()
+	}
+	s.j--
+	s.nbin = s.p[s.j].nbin
+	s.natom = s.p[s.j].natom
+	s.natom++
+
+	switch s.p[s.j].groupKind {
+	case gkNonCapture:
+		// A non-capturing group has nothing more to emit
+		return
+	case gkLookahead, gkNegLookahead:
+		s.emit(tokenT{
+			ttype:    tLookahead,
+			pos:      s.p[s.j].groupPos,
+			negation: s.p[s.j].groupKind == gkNegLookahead,
+		})
+		return
+	case gkLookbehind, gkNegLookbehind:
+		s.lookbehindDepth--
+		s.emit(tokenT{
+			ttype:    tLookbehind,
+			pos:      s.p[s.j].groupPos,
+			negation: s.p[s.j].groupKind == gkNegLookbehind,
+		})
+		return
+	}
+
+	// Now emit the tEndRegister
+	/*
+		dlog.Printf("tEndRegister j=%d pos=%d r#=%d rName=%s",
+			s.j,
+			s.input.pos,
+			s.p[s.j].groupNum,
+			s.p[s.j].groupName)
+	*/
+	s.emit(tokenT{
+		ttype:   tEndRegister,
+		pos:     s.p[s.j].groupPos,
+		regNum:  s.p[s.j].groupNum,
+		regName: s.p[s.j].groupName,
+	})
+}
+
+func (s *reParserStateT) parseGlob(r rune) {
+	if s.natom == 0 {
+		s.emitErrorf(ErrMissingOperand, s.input.pos-1,
+			"Cannot have glob '%c' at pos %d with no preceding item",
+			r, s.input.pos)
+		return
+	}
+
+	token := tokenT{
+		pos: s.input.pos,
+	}
+	switch r {
+	case '*':
+		token.ttype = tGlobStar
+	case '+':
+		token.ttype = tGlobPlus
+	case '?':
+		token.ttype = tGlobQuestion
+	default:
+		panic(fmt.Sprintf("Unexpected '%c' at pos %d", r, s.input.pos))
+	}
+
+	ok, lazy := s.parseLazy()
+	if !ok {
+		return
+	}
+	token.lazy = lazy
+	s.emit(token)
+}
+
+// Is the glob or repetition followed by a '?', making it lazy?
+// If so, the '?' is consumed. Returns ok, lazy
+func (s *reParserStateT) parseLazy() (bool, bool) {
+	ok, r, eof := s.input.peekNextRune()
+	if !ok || eof || r != '?' {
+		// A bad rune will be reported by the next getNextRune()
+		return true, false
+	}
+	ok, _ = s.input.consumeNextRune()
+	return ok, ok
+}
+
+// The largest count allowed in a {m,n} repetition
+const maxRepeatCount = 1000
+
+// Parse "m}", "m,}" or "m,n}" after the "{"
+func (s *reParserStateT) parseLBrace() {
+	startPos := s.input.pos - 1
+	if s.natom == 0 {
+		s.emitErrorf(ErrMissingOperand, startPos,
+			"Cannot have repetition '{' at pos %d with no preceding item",
+			startPos)
+		return
+	}
+
+	ok, min, r := s.parseRepeatCount()
+	if !ok {
+		return
+	}
+	if min == -1 {
+		s.emitErrorf(ErrInvalidRepetition, startPos,
+			"Expected a number after '{' at pos %d", startPos)
+		return
+	}
+
+	max := min
+	if r == ',' {
+		ok, max, r = s.parseRepeatCount()
+		if !ok {
+			return
+		}
+	}
+	if r != '}' {
+		s.emitErrorf(ErrInvalidRepetition, startPos,
+			"Expected '}' to close the repetition at pos %d", startPos)
+		return
+	}
+
+	if max != -1 && min > max {
+		s.emitErrorf(ErrInvalidRepetition, startPos,
+			"The repetition {%d,%d} at pos %d has a minimum greater than its maximum",
+			min, max, startPos)
+		return
+	}
+	if min > maxRepeatCount || max > maxRepeatCount {
+		s.emitErrorf(ErrInvalidRepetition, startPos,
+			"The repetition at pos %d exceeds the limit of %d",
+			startPos, maxRepeatCount)
+		return
+	}
+
+	ok, lazy := s.parseLazy()
+	if !ok {
+		return
+	}
+
+	s.emit(tokenT{
+		ttype:     tRepeat,
+		pos:       startPos,
+		repeatMin: min,
+		repeatMax: max,
+		lazy:      lazy,
+	})
+}
+
+// Reads a decimal number inside a {m,n} repetition, skipping whitespace.
+// Returns ok, the number (or -1 if no digits were present), and the
+// rune that ended the number.
+func (s *reParserStateT) parseRepeatCount() (bool, int, rune) {
+	count := -1
+	ended := false
+	for {
+		ok, r, eof := s.input.getNextRune()
+		if !ok {
+			return false, 0, 0
+		}
+		if eof {
+			s.emitUnexpectedEOF()
+			return false, 0, 0
+		}
+
+		switch {
+		case r == ' ' || r == '\t' || r == '\n':
+			// Whitespace can surround the number, but not split it
+			ended = count != -1
+			continue
+		case r >= '0' && r <= '9' && !ended:
+			if count == -1 {
+				count = 0
+			}
+			// Stop accumulating once past the limit, so that
+			// the count can't overflow; the caller reports it.
+			if count <= maxRepeatCount {
+				count = count*10 + int(r-'0')
+			}
+		case r == ',' || r == '}':
+			return true, count, r
+		default:
+			s.emitErrorf(ErrInvalidRepetition, s.input.pos-utf8.RuneLen(r),
+				"Unexpected '%c' in repetition at pos %d", r, s.input.pos)
+			return false, 0, 0
+		}
+	}
+}
+
+// Parse the group number after the "\\"
+func (s *reParserStateT) parseBackslash() {
+	startPos := s.input.pos - 1
+	digits := make([]rune, 0, 2)
+	for {
+		ok, r, eof := s.input.peekNextRune()
+		if !ok {
+			return
+		}
+		if eof || r < '0' || r > '9' {
+			break
+		}
+		s.input.consumeNextRune()
+		digits = append(digits, r)
+	}
+	if len(digits) == 0 {
+		s.emitErrorf(ErrInvalidBackref, startPos,
+			"Expected a group number after '\\' at pos %d", startPos)
+		return
+	}
+	regNum, err := strconv.Atoi(string(digits))
+	if err != nil || regNum == 0 || regNum > s.groupNumsAllocated {
+		s.emitErrorf(ErrInvalidBackref, startPos,
+			"The backreference at pos %d refers to group %s, which doesn't exist",
+			startPos, string(digits))
+		return
+	}
+	for j := 0; j < s.j; j++ {
+		if s.p[j].groupNum == regNum {
+			s.emitErrorf(ErrInvalidBackref, startPos,
+				"The backreference at pos %d refers to group %d, which is still open",
+				startPos, regNum)
+			return
+		}
+	}
+	s.emitBackref(startPos, regNum, "")
+}
+
+// Emit a backreference, which starts at pos, to either a group
+// number or a group name
+func (s *reParserStateT) emitBackref(pos int, regNum int, regName string) {
+	if s.natom > 1 {
+		s.natom--
+		s.emitConcatenation()
+	}
+	s.emit(tokenT{
+		ttype:   tBackref,
+		pos:     pos,
+		regNum:  regNum,
+		regName: regName,
+	})
+	s.natom++
+}
+
+func (s *reParserStateT) parseSimpleToken(ttype tokenTypeT) {
+	if s.natom > 1 {
+		s.natom--
+		s.emitConcatenation()
+	}
+	s.emit(tokenT{
+		ttype: ttype,
+		pos:   s.input.pos,
+	})
+	s.natom++
+}
+
+func (s *reParserStateT) parseLBracket() {
+	// Look for the RBracket, but take into consideration
+	// that the class name can have a RBracket in it.
+	startPos := s.input.pos
+	endPos := -1
+
+	needColon := false
+	for {
+		// set endPos here; if we get ']' it will be correct.
+		endPos = s.input.pos
+		ok, r, eof := s.input.getNextRune()
+		if eof {
+			s.emitUnexpectedEOF()
+			return
+		}
+		if !ok {
+			return
+		}
+		if needColon {
+			if r == ':' {
+				needColon = false
+				continue
+			}
+		} else {
+			if r == ']' {
+				break
+			}
+		}
+	}
+
+	text := s.input.getStringSlice(startPos, endPos)
+
+	// Do we have just 1 class name, or more?
+	negation := false
+	numColons := 0
+	fcPos := 0
+	scPos := 0
+	for i, c := range text {
+		if numColons == 0 && c == '!' {
+			negation = true
+		}
+		if c == ':' {
+			if numColons == 0 {
+				fcPos = i
+			} else if numColons == 1 {
+				scPos = i
+			}
+			numColons++
+			if numColons == 3 {
+				break
+			}
+		}
+	}
+
+	if s.natom > 1 {
+		s.natom--
+		s.emitConcatenation()
+	}
+
+	// A single class name must look like [:name:] or [!:name:]
+	wellFormed := numColons == 2 && scPos > fcPos+1 &&
+		strings.Trim(text[:fcPos], " \t\n!") == "" &&
+		strings.Count(text[:fcPos], "!") <= 1 &&
+		strings.TrimSpace(text[scPos+1:]) == ""
+
+	if numColons > 2 {
+		s.emit(tokenT{
+			ttype: tDynClass,
+			pos:   startPos,
+			name:  text,
+		})
+	} else if wellFormed {
+		// The parens of an argument must be balanced
+		namePos := startPos + fcPos + 1
+		if off, code, what := checkArg(text[fcPos+1 : scPos]); off != -1 {
+			s.noteErrorf(code, namePos+off,
+				"The class name at pos %d has %s at pos %d", namePos, what, namePos+off)
+			// Keep going, with something in the place of the class
+			s.emit(tokenT{
+				ttype: tAny,
+				pos:   startPos,
+			})
+		} else {
+			s.emit(tokenT{
+				ttype:    tClass,
+				pos:      startPos,
+				name:     text[fcPos+1 : scPos],
+				negation: negation,
+			})
+		}
+	} else {
+		// Keep going, with something in the place of the class
+		s.noteErrorf(ErrInvalidClass, startPos-1,
+			"The class at pos %d should look like [:name:]", startPos-1)
+		s.emit(tokenT{
+			ttype: tAny,
+			pos:   startPos,
+		})
+	}
+	s.natom++
+}
+
+func (s *reParserStateT) emit(token tokenT) {
+	s.tokens = append(s.tokens, token)
+}
+
+func (s *reParserStateT) emitConcatenation() {
+	// Add a concatention
+	s.emit(tokenT{
+		ttype: tConcat,
+		pos:   -1,
+	})
+}
+func (s *reParserStateT) emitAlternation() {
+	s.emit(tokenT{
+		ttype: tAlternate,
+	})
+}
+
+// Emit an Error for the problem at byte offset pos, which
+// stops the parse
+func (s *reParserStateT) emitErrorf(code ErrorCode, pos int, f string, args ...any) {
+	s.noteErrorf(code, pos, f, args...)
+	s.emittedError = true
+}
+
+// Emit an Error for the problem at byte offset pos, but the
+// parse can keep going, to find more problems.
+func (s *reParserStateT) noteErrorf(code ErrorCode, pos int, f string, args ...any) {
+	s.errs = append(s.errs, NewError(s.input.input, pos, code, fmt.Sprintf(f, args...)))
+}
+
+func (s *reParserStateT) emitRuneError() {
+	s.emitErrorf(ErrInvalidUTF8, s.input.pos,
+		"Bytes starting at position %d aren't valid UTF-8", s.input.pos)
+}
+func (s *reParserStateT) emitUnexpectedEOF() {
+	s.emitErrorf(ErrUnexpectedEOF, len(s.input.input), "Unexpected end of string")
+}
@@ -1,4 +1,4 @@
-package objregexp
+package syntax
 
 // Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
 
@@ -240,3 +240,198 @@ func (s *MySuite) TestParserRegs01(c *C) {
 	dlog.Printf("tokenString: %s", tokenString)
 	c.Assert(tokenString, Equals, "C)C).C)?.CCC||).")
 }
+
+func (s *MySuite) TestParserRepeat01(c *C) {
+	text := "[:foo:]{2} [:bar:]{ 3, } [:baz:]{0,4}"
+	tokens, err := parseRegex(text)
+	c.Assert(err, IsNil)
+
+	c.Assert(makeTokensString(tokens), Equals, "C{C{.C{.")
+
+	c.Check(tokens[1].repeatMin, Equals, 2)
+	c.Check(tokens[1].repeatMax, Equals, 2)
+
+	c.Check(tokens[3].repeatMin, Equals, 3)
+	c.Check(tokens[3].repeatMax, Equals, -1)
+
+	c.Check(tokens[6].repeatMin, Equals, 0)
+	c.Check(tokens[6].repeatMax, Equals, 4)
+}
+
+// Bad repetitions
+func (s *MySuite) TestParserRepeat02(c *C) {
+	_, err := parseRegex("[:foo:]{5,2}")
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals,
+		"The repetition {5,2} at pos 7 has a minimum greater than its maximum")
+
+	_, err = parseRegex("{2}")
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals,
+		"Cannot have repetition '{' at pos 0 with no preceding item")
+
+	_, err = parseRegex("[:foo:]{,2}")
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Expected a number after '{' at pos 7")
+
+	_, err = parseRegex("[:foo:]{1 2}")
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Unexpected '2' in repetition at pos 11")
+
+	_, err = parseRegex("[:foo:]{1001}")
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "The repetition at pos 7 exceeds the limit of 1000")
+
+	_, err = parseRegex("[:foo:]{2")
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Unexpected end of string")
+}
+
+func (s *MySuite) TestParserLazy01(c *C) {
+	text := "[:a:]*? [:b:]+? [:c:]?? [:d:]{1,2}? [:e:]* [:f:]?"
+	tokens, err := parseRegex(text)
+	c.Assert(err, IsNil)
+
+	c.Assert(makeTokensString(tokens), Equals, "C*C+.C?.C{.C*.C?.")
+
+	c.Check(tokens[1].lazy, Equals, true)
+	c.Check(tokens[3].lazy, Equals, true)
+	c.Check(tokens[6].lazy, Equals, true)
+	c.Check(tokens[9].lazy, Equals, true)
+	c.Check(tokens[12].lazy, Equals, false)
+	c.Check(tokens[15].lazy, Equals, false)
+}
+
+// Non-capturing groups don't emit registers
+func (s *MySuite) TestParserNonCapturing01(c *C) {
+	text := "(?:[:a:] | [:b:])+ ([:c:]) (?P<x>[:d:])"
+	tokens, err := parseRegex(text)
+	c.Assert(err, IsNil)
+
+	c.Assert(makeTokensString(tokens), Equals, "CC|+C).C).")
+	c.Check(tokens[5].regNum, Equals, 1)
+	c.Check(tokens[8].regNum, Equals, 2)
+	c.Check(tokens[8].regName, Equals, "x")
+}
+
+func (s *MySuite) TestParserNonCapturing02(c *C) {
+	_, err := parseRegex("(?x[:a:])")
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Expected 'P', ':', '=', '!', '<' or '#' after '(?' at pos 3")
+
+	_, err = parseRegex("(?:)")
+	c.Assert(err, NotNil)
+
+	_, err = parseRegex("(?:[:a:]")
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Unexpected end of string")
+}
+
+func (s *MySuite) TestParserLookahead01(c *C) {
+	text := "[:a:] (?=[:b:] [:c:]) (?![:d:]) ([:e:])"
+	tokens, err := parseRegex(text)
+	c.Assert(err, IsNil)
+
+	c.Assert(makeTokensString(tokens), Equals, "CCC.=.C=.C).")
+	c.Check(tokens[4].negation, Equals, false)
+	c.Check(tokens[7].negation, Equals, true)
+	// Lookaheads don't use up register numbers
+	c.Check(tokens[10].regNum, Equals, 1)
+}
+
+func (s *MySuite) TestParserLookbehind01(c *C) {
+	text := "(?<=[:a:] [:b:]) (?<![:c:]) [:d:]"
+	tokens, err := parseRegex(text)
+	c.Assert(err, IsNil)
+
+	c.Assert(makeTokensString(tokens), Equals, "CC.<C<.C.")
+	c.Check(tokens[3].negation, Equals, false)
+	c.Check(tokens[5].negation, Equals, true)
+	// The assertions are at their open parens
+	c.Check(tokens[3].pos, Equals, 0)
+	c.Check(tokens[5].pos, Equals, 17)
+
+	// Assertions can't be nested inside a lookbehind
+	_, err = parseRegex("(?<=[:a:] (?=[:b:]))")
+	c.Check(err, ErrorMatches, "The assertion at pos 13 cannot be inside a lookbehind")
+
+	_, err = parseRegex("(?<x[:a:])")
+	c.Check(err, ErrorMatches, "Expected '=' or '!' after '\\(\\?<' at pos 4")
+}
+
+func (s *MySuite) TestParserBackref01(c *C) {
+	text := "([:a:]) (?P<x>[:b:]) \\1 (?P=x)"
+	tokens, err := parseRegex(text)
+	c.Assert(err, IsNil)
+
+	c.Assert(makeTokensString(tokens), Equals, "C)C).B.B.")
+	c.Check(tokens[5].regNum, Equals, 1)
+	c.Check(tokens[7].regName, Equals, "x")
+
+	_, err = parseRegex("([:a:]) \\")
+	c.Check(err, ErrorMatches, "Expected a group number after '\\\\' at pos 8")
+
+	_, err = parseRegex("([:a:]) \\2")
+	c.Check(err, ErrorMatches, "The backreference at pos 8 refers to group 2, which doesn't exist")
+
+	_, err = parseRegex("([:a:] \\1)")
+	c.Check(err, ErrorMatches, "The backreference at pos 7 refers to group 1, which is still open")
+
+	_, err = parseRegex("([:a:]) (?P=)")
+	c.Check(err, ErrorMatches, "The backreference name at pos 12 is empty")
+}
+
+func (s *MySuite) TestParserComment01(c *C) {
+	text := `
+		# Match a vowel
+		[:a:]
+		(?# and then a consonant )[:b:] # at the end of a line
+		# The end`
+	tokens, err := parseRegex(text)
+	c.Assert(err, IsNil)
+	c.Assert(makeTokensString(tokens), Equals, "CC.")
+
+	// A comment can contain things that aren't valid regex syntax
+	tokens, err = parseRegex("[:a:] # ( | ]")
+	c.Assert(err, IsNil)
+	c.Assert(makeTokensString(tokens), Equals, "C")
+
+	_, err = parseRegex("[:a:] (?# no end")
+	c.Check(err, ErrorMatches, "Unexpected end of string")
+}
+
+func (s *MySuite) TestParserMalformedClass01(c *C) {
+	for _, text := range []string{"[:a]", "[a]", "[]", "[::]", "[!a:]", "[x:a:]", "[:a:x]", "[!!:a:]"} {
+		_, err := parseRegex(text)
+		c.Check(err, ErrorMatches, "The class at pos 0 should look like \\[:name:\\]",
+			Commentf(text))
+	}
+
+	tokens, err := parseRegex("[ ! :a: ]")
+	c.Assert(err, IsNil)
+	c.Check(tokens[0].name, Equals, "a")
+	c.Check(tokens[0].negation, Equals, true)
+}
+
+// A regex with a little of everything in it
+const benchRegex = `^ (?P<first>[:a:]+) [:b: && !:c:]{2,5}?
+	(?:[:d:] | [:e: || (:f: && :g:)])* (?=[!:h:]) \1 $ # a comment`
+
+// Run with "go test -check.b"
+func (s *MySuite) BenchmarkParse01(c *C) {
+	for i := 0; i < c.N; i++ {
+		_, err := Parse(benchRegex)
+		if err != nil {
+			c.Fatal(err)
+		}
+	}
+}
+
+func (s *MySuite) BenchmarkParseClass01(c *C) {
+	for i := 0; i < c.N; i++ {
+		_, err := ParseClass(":e: || !(:f: && :g:) && :h:")
+		if err != nil {
+			c.Fatal(err)
+		}
+	}
+}
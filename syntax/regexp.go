@@ -0,0 +1,188 @@
+// Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
+
+// Package syntax parses objregexp regexes into syntax trees. It is
+// for tools which work with regexes, like linters, formatters and
+// rewriters. The objregexp Compiler builds its NFAs from these trees.
+//
+// A syntax tree only has names of classes and identities; whether the
+// names are registered is up to the objregexp Compiler.
+package syntax
+
+import "strings"
+
+// An Op is the kind of a Regexp node
+type Op uint8
+
+const (
+	// An unitialized Op will be 0 but with no enum name
+	OpClass      Op = iota + 1 // [:Name:] or [!:Name:], a class or identity
+	OpClassExpr                // [:a: && :b:], a dynamic class in Class
+	OpAnyObject                // .
+	OpBeginText                // ^
+	OpEndText                  // $
+	OpConcat                   // Sub[0] Sub[1] ...
+	OpAlternate                // Sub[0] | Sub[1] ...
+	OpStar                     // Sub[0]*
+	OpPlus                     // Sub[0]+
+	OpQuest                    // Sub[0]?
+	OpRepeat                   // Sub[0]{Min,Max}
+	OpCapture                  // (Sub[0]) or (?P<Name>Sub[0])
+	OpLookahead                // (?=Sub[0]) or (?!Sub[0])
+	OpLookbehind               // (?<=Sub[0]) or (?<!Sub[0])
+	OpBackref                  // \Cap or (?P=Name)
+)
+
+func (s Op) String() string {
+	switch s {
+	case OpClass:
+		return "Class"
+	case OpClassExpr:
+		return "ClassExpr"
+	case OpAnyObject:
+		return "AnyObject"
+	case OpBeginText:
+		return "BeginText"
+	case OpEndText:
+		return "EndText"
+	case OpConcat:
+		return "Concat"
+	case OpAlternate:
+		return "Alternate"
+	case OpStar:
+		return "Star"
+	case OpPlus:
+		return "Plus"
+	case OpQuest:
+		return "Quest"
+	case OpRepeat:
+		return "Repeat"
+	case OpCapture:
+		return "Capture"
+	case OpLookahead:
+		return "Lookahead"
+	case OpLookbehind:
+		return "Lookbehind"
+	case OpBackref:
+		return "Backref"
+	default:
+		return "Op?"
+	}
+}
+
+// A Regexp is a node in the syntax tree of a regex.
+// Non-capturing groups don't have nodes of their own; their
+// contents are put in the place of the group.
+type Regexp struct {
+	Op  Op
+	Sub []*Regexp
+
+	// For OpClass, the name of the class or identity.
+	// For OpClassExpr, the text between the brackets.
+	// For OpCapture and OpBackref, the name of the group, if it has one.
+	Name string
+
+	// For OpClass, OpLookahead and OpLookbehind, is the test negated?
+	Negate bool
+
+	// For OpStar, OpPlus, OpQuest and OpRepeat, prefer as few
+	// repetitions as possible
+	Lazy bool
+
+	// For OpRepeat, the minimum and maximum number of repetitions.
+	// Max is -1 if there is no maximum.
+	Min, Max int
+
+	// For OpCapture and OpBackref, the group number
+	Cap int
+
+	// For OpClassExpr, the expression
+	Class *ClassExpr
+
+	// The byte offset in the regex of this node's own syntax: the
+	// '[' of a class, the operator of a glob or repetition, the '('
+	// of a group, or the '\' of a backreference. For OpConcat and
+	// OpAlternate, it is the Pos of the first Sub.
+	Pos int
+}
+
+// A ClassOp is the kind of a ClassExpr node
+type ClassOp uint8
+
+const (
+	// An unitialized ClassOp will be 0 but with no enum name
+	ClassName ClassOp = iota + 1 // :Name:
+	ClassNot                     // !Sub[0]
+	ClassAnd                     // Sub[0] && Sub[1] && ...
+	ClassOr                      // Sub[0] || Sub[1] || ...
+)
+
+func (s ClassOp) String() string {
+	switch s {
+	case ClassName:
+		return "Name"
+	case ClassNot:
+		return "Not"
+	case ClassAnd:
+		return "And"
+	case ClassOr:
+		return "Or"
+	default:
+		return "ClassOp?"
+	}
+}
+
+// A ClassExpr is a node in the expression of a dynamic class,
+// like "[:lower: && !:vowel:]"
+type ClassExpr struct {
+	Op  ClassOp
+	Sub []*ClassExpr
+
+	// For ClassName, the name of the class or identity
+	Name string
+
+	// The byte offset in the regex of the first ':' of a name, or
+	// the '!' of a ClassNot. For ClassAnd and ClassOr, it is the Pos
+	// of the first Sub.
+	Pos int
+}
+
+// SplitArg splits a class name which passes an argument to a class
+// factory, like "longer(5)", into the factory's name and the argument,
+// "longer" and "5". argOffset is the byte offset of the argument in the
+// name. ok is false if the name doesn't have an argument.
+func SplitArg(name string) (factory string, arg string, argOffset int, ok bool) {
+	open := strings.IndexByte(name, '(')
+	if open <= 0 || !strings.HasSuffix(name, ")") {
+		return "", "", 0, false
+	}
+	return name[:open], name[open+1 : len(name)-1], open + 1, true
+}
+
+// Find a problem with the parens of a class name, which can only be
+// used around an argument at its end. A "field=value" name, which has
+// a '=' before its first paren, isn't checked, as the value can have
+// any parens in it. Returns the byte offset of the problem in the name,
+// or -1 if there isn't one.
+func checkArg(name string) (int, ErrorCode, string) {
+	open := strings.IndexByte(name, '(')
+	close := strings.IndexByte(name, ')')
+	switch {
+	case open == -1 && close == -1:
+		return -1, 0, ""
+	case strings.Contains(name[:strings.IndexAny(name, "()")], "="):
+		return -1, 0, ""
+	case open == -1 || (close != -1 && close < open):
+		return close, ErrUnbalancedParen, "')' without a '('"
+	case open == 0:
+		return open, ErrInvalidName, "'(' without a class name before it"
+	case close == -1:
+		return open, ErrUnbalancedParen, "'(' without a ')'"
+	}
+	if i := strings.IndexByte(name[open+1:], '('); i != -1 && open+1+i < close {
+		return open + 1 + i, ErrInvalidName, "'(' inside of a class argument"
+	}
+	if close != len(name)-1 {
+		return close + 1, ErrInvalidName, "text after the ')' of a class argument"
+	}
+	return -1, 0, ""
+}
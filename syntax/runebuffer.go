@@ -1,4 +1,4 @@
-package objregexp
+package syntax
 
 import (
 	"unicode/utf8"
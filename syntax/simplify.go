@@ -0,0 +1,268 @@
+// Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
+
+package syntax
+
+// Simplify returns a tree which matches the same objects, with the
+// same capture groups, as s does, but which makes a smaller NFA:
+//
+//   - Alternate choices which start with the same test of one object
+//     have it factored out: "[:a:] [:b:] | [:a:] [:c:]" becomes
+//     "[:a:] (?:[:b:] | [:c:])", so that [:a:] is tested once. This
+//     isn't done if what follows the test, in any of the choices, is
+//     empty, or has a capture group or a quantifier in it.
+//   - Alternate choices which are each a test of a single object
+//     are merged into one test: "[:a:] | [:b:]" becomes "[:a: || :b:]".
+//   - Nested quantifiers are collapsed: "(?:[:a:]*)*" becomes "[:a:]*".
+//   - Empty sequences, left by Concat nodes with no subs, are removed.
+//
+// Only choices which are next to each other are factored or merged,
+// so that the order of preference between them doesn't change. Nothing
+// with a capture group in it is collapsed. s is not changed.
+func (s *Regexp) Simplify() *Regexp {
+	switch s.Op {
+	case OpConcat:
+		re := &Regexp{Op: OpConcat, Pos: s.Pos}
+		for _, sub := range s.Sub {
+			sub = sub.Simplify()
+			if sub.Op == OpConcat {
+				// This also drops the empty ones
+				re.Sub = append(re.Sub, sub.Sub...)
+			} else {
+				re.Sub = append(re.Sub, sub)
+			}
+		}
+		if len(re.Sub) == 1 {
+			return re.Sub[0]
+		}
+		return re
+
+	case OpAlternate:
+		subs := make([]*Regexp, 0, len(s.Sub))
+		for _, sub := range s.Sub {
+			sub = sub.Simplify()
+			if sub.Op == OpAlternate {
+				subs = append(subs, sub.Sub...)
+			} else {
+				subs = append(subs, sub)
+			}
+		}
+		subs = factorPrefixes(subs)
+		subs = mergeSingleTests(subs)
+		if len(subs) == 1 {
+			return subs[0]
+		}
+		return &Regexp{Op: OpAlternate, Sub: subs, Pos: s.Pos}
+
+	case OpStar, OpPlus, OpQuest:
+		re := *s
+		sub := s.Sub[0].Simplify()
+		if isEmpty(sub) {
+			return sub
+		}
+		if sub.Op == OpStar || sub.Op == OpPlus || sub.Op == OpQuest {
+			if sub.Lazy == s.Lazy && !sub.hasCapture() {
+				// Only x++ is x+, and only x?? is x?;
+				// the others are all x*
+				if sub.Op != s.Op || s.Op == OpStar {
+					re.Op = OpStar
+				}
+				sub = sub.Sub[0]
+			}
+		}
+		re.Sub = []*Regexp{sub}
+		return &re
+
+	case OpRepeat, OpCapture, OpLookahead, OpLookbehind:
+		re := *s
+		re.Sub = []*Regexp{s.Sub[0].Simplify()}
+		if s.Op == OpRepeat && isEmpty(re.Sub[0]) {
+			return re.Sub[0]
+		}
+		return &re
+
+	default:
+		re := *s
+		return &re
+	}
+}
+
+// Is re an empty sequence, which matches without consuming anything?
+func isEmpty(re *Regexp) bool {
+	return re.Op == OpConcat && len(re.Sub) == 0
+}
+
+// Does re, or anything inside of it, capture?
+func (s *Regexp) hasCapture() bool {
+	if s.Op == OpCapture {
+		return true
+	}
+	for _, sub := range s.Sub {
+		if sub.hasCapture() {
+			return true
+		}
+	}
+	return false
+}
+
+// The first item of the sequence, and the items after it
+func splitFirst(re *Regexp) (*Regexp, *Regexp) {
+	if re.Op != OpConcat {
+		return re, &Regexp{Op: OpConcat, Pos: re.Pos}
+	}
+	rest := &Regexp{Op: OpConcat, Sub: re.Sub[1:], Pos: re.Pos}
+	if len(rest.Sub) == 1 {
+		return re.Sub[0], rest.Sub[0]
+	}
+	return re.Sub[0], rest
+}
+
+// Factor out the first item of runs of choices which start with the
+// same test of one object. After that object, each choice goes on as
+// before, in the same order of preference. Only choices whose rests have
+// no captures, and no quantifiers, are factored, as the engine keeps
+// one thread per state, and putting the rests together could change
+// which of them wins. Neither are choices which would leave an empty
+// rest, as "(?: | ...)" isn't a regex.
+func factorPrefixes(subs []*Regexp) []*Regexp {
+	result := make([]*Regexp, 0, len(subs))
+	for i := 0; i < len(subs); {
+		first, rest := splitFirst(subs[i])
+		if !canFactor(first, rest) {
+			result = append(result, subs[i])
+			i++
+			continue
+		}
+		j := i + 1
+		for ; j < len(subs); j++ {
+			other, otherRest := splitFirst(subs[j])
+			if !first.Equal(other) || !canFactor(other, otherRest) {
+				break
+			}
+		}
+		if j == i+1 {
+			result = append(result, subs[i])
+			i++
+			continue
+		}
+
+		rests := &Regexp{Op: OpAlternate, Pos: subs[i].Pos}
+		for _, sub := range subs[i:j] {
+			_, r := splitFirst(sub)
+			rests.Sub = append(rests.Sub, r)
+		}
+		factored := &Regexp{Op: OpConcat, Pos: subs[i].Pos,
+			Sub: []*Regexp{first, rests}}
+		// The rests might have prefixes to factor, too
+		result = append(result, factored.Simplify())
+		i = j
+	}
+	return result
+}
+
+// Can first be factored out of a choice, leaving rest?
+func canFactor(first, rest *Regexp) bool {
+	if !isSingleTest(first) && first.Op != OpAnyObject {
+		return false
+	}
+	return !isEmpty(rest) && !rest.hasCapture() && !rest.hasQuantifier()
+}
+
+// Does re, or anything inside of it, have a quantifier?
+func (s *Regexp) hasQuantifier() bool {
+	switch s.Op {
+	case OpStar, OpPlus, OpQuest, OpRepeat:
+		return true
+	}
+	for _, sub := range s.Sub {
+		if sub.hasQuantifier() {
+			return true
+		}
+	}
+	return false
+}
+
+// Is re a test of a single object, which can be put in a ClassExpr?
+func isSingleTest(re *Regexp) bool {
+	return re.Op == OpClass || re.Op == OpClassExpr
+}
+
+// The ClassExpr which does the same test as re
+func toClassExpr(re *Regexp) *ClassExpr {
+	if re.Op == OpClassExpr {
+		return re.Class
+	}
+	// The Pos of a name is at its first ':'
+	name := &ClassExpr{Op: ClassName, Name: re.Name, Pos: re.Pos + 1}
+	if re.Negate {
+		name.Pos++
+		return &ClassExpr{Op: ClassNot, Sub: []*ClassExpr{name}, Pos: re.Pos + 1}
+	}
+	return name
+}
+
+// Merge runs of choices which each test a single object into one test.
+// Each choice in a run goes on to the same place after consuming one
+// object, so trying them one at a time is the same as trying them all
+// at once.
+func mergeSingleTests(subs []*Regexp) []*Regexp {
+	result := make([]*Regexp, 0, len(subs))
+	for i := 0; i < len(subs); {
+		j := i
+		for j < len(subs) && isSingleTest(subs[j]) {
+			j++
+		}
+		if j-i < 2 {
+			result = append(result, subs[i])
+			i++
+			continue
+		}
+		expr := &ClassExpr{Op: ClassOr, Pos: subs[i].Pos + 1}
+		for _, sub := range subs[i:j] {
+			ce := toClassExpr(sub)
+			if ce.Op == ClassOr {
+				expr.Sub = append(expr.Sub, ce.Sub...)
+			} else {
+				expr.Sub = append(expr.Sub, ce)
+			}
+		}
+		result = append(result, &Regexp{Op: OpClassExpr, Name: expr.String(),
+			Class: expr, Pos: subs[i].Pos})
+		i = j
+	}
+	return result
+}
+
+// Equal reports whether s and re have the same structure. The positions
+// are not compared.
+func (s *Regexp) Equal(re *Regexp) bool {
+	// The Name of an OpClassExpr is its text, which can be spaced
+	// in different ways
+	if s.Op != re.Op || (s.Name != re.Name && s.Op != OpClassExpr) || s.Negate != re.Negate ||
+		s.Lazy != re.Lazy || s.Min != re.Min || s.Max != re.Max ||
+		s.Cap != re.Cap || len(s.Sub) != len(re.Sub) {
+		return false
+	}
+	if s.Op == OpClassExpr && !s.Class.Equal(re.Class) {
+		return false
+	}
+	for i, sub := range s.Sub {
+		if !sub.Equal(re.Sub[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Equal reports whether s and expr have the same structure. The
+// positions are not compared.
+func (s *ClassExpr) Equal(expr *ClassExpr) bool {
+	if s.Op != expr.Op || s.Name != expr.Name || len(s.Sub) != len(expr.Sub) {
+		return false
+	}
+	for i, sub := range s.Sub {
+		if !sub.Equal(expr.Sub[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+// Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
+
+package syntax
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestSimplify01(c *C) {
+	tests := []struct {
+		pattern  string
+		expected string
+	}{
+		// Common prefixes are factored out
+		{"[:a:] [:b:] | [:a:] [:c:]", "[:a:] [:b: || :c:]"},
+		{"[:a:] [:b:] [:c:] | [:a:] [:b:] [:d:] | [:e:]", "[:a:] [:b:] [:c: || :d:] | [:e:]"},
+		{". [:b:] [:c:] | . [:b:] [:d:]", ". [:b:] [:c: || :d:]"},
+		{"[:a:] [:b:] | [:a:] [:c:] [:d:]", "[:a:] (?:[:b:] | [:c:] [:d:])"},
+		// But only for choices next to each other
+		{"[:a:] [:b:] | [:c:] [:d:] | [:a:] [:d:]", "[:a:] [:b:] | [:c:] [:d:] | [:a:] [:d:]"},
+		// And not for captures, or for rests with captures or
+		// quantifiers, or for prefixes wider than one object
+		{"([:a:]) [:b:] | ([:a:]) [:c:]", "([:a:]) [:b:] | ([:a:]) [:c:]"},
+		{"[:a:] ([:b:]) | [:a:] ([:c:])", "[:a:] ([:b:]) | [:a:] ([:c:])"},
+		{"[:a:] [:b:]* | [:a:] [:c:]", "[:a:] [:b:]* | [:a:] [:c:]"},
+		{"[:a:]? ([:a:]) | [:a:]? [:b:]*", "[:a:]? ([:a:]) | [:a:]? [:b:]*"},
+		{"[!:a:]*? [:c:] | [!:a:]*? .", "[!:a:]*? [:c:] | [!:a:]*? ."},
+		// Or when a rest would be empty
+		{"[:a:] | [:a:] [:b:]", "[:a:] | [:a:] [:b:]"},
+		{". [:b:] [:c:] | . [:b:]", ". (?:[:b:] [:c:] | [:b:])"},
+		{"[:a:] | [:a:]", "[:a: || :a:]"},
+
+		// Single tests are merged
+		{"[:a:] | [!:b:] | [:c: && :d:]", "[:a: || !:b: || :c: && :d:]"},
+		{"[:a:] | [:b:] | . | [:c:] | [:d: || :e:]", "[:a: || :b:] | . | [:c: || :d: || :e:]"},
+		{"([:a:] | [:b:])", "([:a: || :b:])"},
+
+		// Nested quantifiers are collapsed
+		{"(?:[:a:]*)*", "[:a:]*"},
+		{"(?:[:a:]+)+", "[:a:]+"},
+		{"(?:[:a:]?)?", "[:a:]?"},
+		{"(?:[:a:]+)?", "[:a:]*"},
+		{"(?:[:a:]?)+", "[:a:]*"},
+		{"(?:[:a:]*?)*?", "[:a:]*?"},
+		{"(?:(?:[:a:]+)*)+", "[:a:]*"},
+		// But not with different laziness, or captures
+		{"(?:[:a:]*?)*", "(?:[:a:]*?)*"},
+		{"(?:([:a:])*)*", "(?:([:a:])*)*"},
+		{"([:a:]*)*", "([:a:]*)*"},
+	}
+
+	for _, t := range tests {
+		re, err := Parse(t.pattern)
+		c.Assert(err, IsNil, Commentf("%q", t.pattern))
+		c.Check(re.Simplify().String(), Equals, t.expected, Commentf("%q", t.pattern))
+		// What it simplifies to is a regex, too
+		_, err = Parse(re.Simplify().String())
+		c.Check(err, IsNil, Commentf("%q", t.pattern))
+		// The original is untouched
+		c.Check(re.String(), Equals, mustFormat(t.pattern))
+	}
+}
+
+// Empty sequences, which only a built tree can have, are removed
+func (s *MySuite) TestSimplify02(c *C) {
+	a := &Regexp{Op: OpClass, Name: "a"}
+	empty := &Regexp{Op: OpConcat}
+
+	re := &Regexp{Op: OpConcat, Sub: []*Regexp{
+		empty, a, &Regexp{Op: OpStar, Sub: []*Regexp{empty}}, empty}}
+	c.Check(re.Simplify().String(), Equals, "[:a:]")
+
+	re = &Regexp{Op: OpConcat, Sub: []*Regexp{empty}}
+	c.Check(isEmpty(re.Simplify()), Equals, true)
+}
+
+func (s *MySuite) TestEqual01(c *C) {
+	re1, err := Parse("[:a: && :b:] [:c:]*")
+	c.Assert(err, IsNil)
+	re2, err := Parse("[ :a:&&:b: ]   [:c:]*")
+	c.Assert(err, IsNil)
+	re3, err := Parse("[:a: && :b:] [:c:]*?")
+	c.Assert(err, IsNil)
+
+	c.Check(re1.Equal(re2), Equals, true)
+	c.Check(re1.Equal(re3), Equals, false)
+}
+
+func mustFormat(pattern string) string {
+	text, err := Format(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return text
+}
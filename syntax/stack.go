@@ -1,4 +1,4 @@
-package objregexp
+package syntax
 
 // Modified
 // from https://github.com/AlexandreChamard/go-generic
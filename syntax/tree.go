@@ -0,0 +1,184 @@
+// Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
+
+package syntax
+
+import (
+	"fmt"
+)
+
+// The state needed to convert a stream of postfix tokenT's into
+// a syntax tree. Literals push nodes onto the stack, and operators
+// pop their operands off of it.
+type treeBuilderT struct {
+	// The regex being parsed, for reporting errors
+	pattern string
+
+	stack stackT[*Regexp]
+
+	// The names of the capture groups which have been closed,
+	// mapped to their group numbers
+	groupNames map[string]int
+
+	// The errors found while building the tree
+	errs []*Error
+}
+
+func (s *treeBuilderT) Initialize(pattern string) {
+	s.pattern = pattern
+	s.stack = NewStack[*Regexp]()
+	s.groupNames = make(map[string]int)
+}
+
+// Create an Error for the problem at byte offset pos
+func (s *treeBuilderT) errorf(code ErrorCode, pos int, f string, args ...any) *Error {
+	return NewError(s.pattern, pos, code, fmt.Sprintf(f, args...))
+}
+
+// Record an error, and push a node in place of the one that had
+// the error, so that the tree can still be built.
+func (s *treeBuilderT) noteError(err *Error, pos int) {
+	s.errs = append(s.errs, err)
+	s.stack.Push(&Regexp{Op: OpAnyObject, Pos: pos})
+}
+
+func (s *treeBuilderT) pop() *Regexp {
+	re := s.stack.Top()
+	s.stack.Pop()
+	return re
+}
+
+// Make the node on top of the stack the sub of re, which replaces it
+func (s *treeBuilderT) wrapTop(re *Regexp) {
+	re.Sub = []*Regexp{s.pop()}
+	s.stack.Push(re)
+}
+
+// Pop 2 nodes, and push a node with op which has both of them.
+// If either of them already has op, its subs are used instead,
+// so that "a b c" is one OpConcat with 3 subs.
+func (s *treeBuilderT) pushBinary(op Op) {
+	re2 := s.pop()
+	re1 := s.pop()
+	re := &Regexp{Op: op, Pos: re1.Pos}
+	for _, sub := range []*Regexp{re1, re2} {
+		if sub.Op == op {
+			re.Sub = append(re.Sub, sub.Sub...)
+		} else {
+			re.Sub = append(re.Sub, sub)
+		}
+	}
+	s.stack.Push(re)
+}
+
+func (s *treeBuilderT) add(token tokenT) {
+	switch token.ttype {
+	case tClass:
+		// token.pos is after the '['
+		s.stack.Push(&Regexp{Op: OpClass, Name: token.name,
+			Negate: token.negation, Pos: token.pos - 1})
+
+	case tDynClass:
+		expr, err := ParseClass(token.name)
+		if err != nil {
+			s.noteError(s.dynClassError(token, err), token.pos-1)
+			break
+		}
+		expr.rebase(token.pos)
+		s.stack.Push(&Regexp{Op: OpClassExpr, Name: token.name,
+			Class: expr, Pos: token.pos - 1})
+
+	case tConcat:
+		s.pushBinary(OpConcat)
+
+	case tAlternate:
+		s.pushBinary(OpAlternate)
+
+	case tGlobStar:
+		// token.pos is after the glob
+		s.wrapTop(&Regexp{Op: OpStar, Lazy: token.lazy, Pos: token.pos - 1})
+
+	case tGlobPlus:
+		s.wrapTop(&Regexp{Op: OpPlus, Lazy: token.lazy, Pos: token.pos - 1})
+
+	case tGlobQuestion:
+		s.wrapTop(&Regexp{Op: OpQuest, Lazy: token.lazy, Pos: token.pos - 1})
+
+	case tRepeat:
+		s.wrapTop(&Regexp{Op: OpRepeat, Lazy: token.lazy, Min: token.repeatMin,
+			Max: token.repeatMax, Pos: token.pos})
+
+	case tAny:
+		// token.pos is after the rune
+		s.stack.Push(&Regexp{Op: OpAnyObject, Pos: token.pos - 1})
+
+	case tAssertBegin:
+		s.stack.Push(&Regexp{Op: OpBeginText, Pos: token.pos - 1})
+
+	case tAssertEnd:
+		s.stack.Push(&Regexp{Op: OpEndText, Pos: token.pos - 1})
+
+	case tLookahead:
+		s.wrapTop(&Regexp{Op: OpLookahead, Negate: token.negation, Pos: token.pos})
+
+	case tLookbehind:
+		s.wrapTop(&Regexp{Op: OpLookbehind, Negate: token.negation, Pos: token.pos})
+
+	case tBackref:
+		regNum := token.regNum
+		if token.regName != "" {
+			var has bool
+			regNum, has = s.groupNames[token.regName]
+			if !has {
+				s.noteError(s.errorf(ErrInvalidBackref, token.pos,
+					"The backreference at pos %d refers to group '%s', which doesn't exist or is still open",
+					token.pos, token.regName), token.pos)
+				break
+			}
+		}
+		s.stack.Push(&Regexp{Op: OpBackref, Cap: regNum, Name: token.regName,
+			Pos: token.pos})
+
+	case tEndRegister:
+		if token.regName != "" {
+			if groupNum, has := s.groupNames[token.regName]; has {
+				// The group is still there, so keep going.
+				s.errs = append(s.errs, s.errorf(ErrInvalidGroup, token.pos,
+					"Capture group name '%s' is for registers %d and %d", token.regName,
+					groupNum, token.regNum))
+			} else {
+				s.groupNames[token.regName] = token.regNum
+			}
+		}
+		s.wrapTop(&Regexp{Op: OpCapture, Cap: token.regNum, Name: token.regName,
+			Pos: token.pos})
+
+	default:
+		panic(fmt.Sprintf("%s not handled", string(token.ttype)))
+	}
+}
+
+// Report the error in a dynamic class at its position in the whole regex
+func (s *treeBuilderT) dynClassError(token tokenT, err error) *Error {
+	serr := err.(*Error)
+	serr.rebase(s.pattern, token.pos)
+	serr.Msg = fmt.Sprintf("Parsing class string at pos %d: %s",
+		token.pos, serr.Msg)
+	return serr
+}
+
+// Returns the tree. If the parse stopped early, there can be more
+// than one node left on the stack; they are put in an OpConcat.
+func (s *treeBuilderT) tree() *Regexp {
+	switch s.stack.Size() {
+	case 0:
+		return nil
+	case 1:
+		return s.pop()
+	}
+	re := &Regexp{Op: OpConcat, Sub: make([]*Regexp, s.stack.Size())}
+	for i := len(re.Sub) - 1; i >= 0; i-- {
+		re.Sub[i] = s.pop()
+	}
+	re.Pos = re.Sub[0].Pos
+	return re
+}
@@ -0,0 +1,236 @@
+// Copyright 2022 by Gilbert Ramirez <gram@alumni.rice.edu>
+
+package syntax
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestParseTree01(c *C) {
+	re, err := Parse("[:a:] [!:b:]* (?:[:c:] | . | $)")
+	c.Assert(err, IsNil)
+
+	c.Assert(re.Op, Equals, OpConcat)
+	c.Assert(len(re.Sub), Equals, 3)
+	c.Check(re.Pos, Equals, 0)
+
+	c.Check(re.Sub[0].Op, Equals, OpClass)
+	c.Check(re.Sub[0].Name, Equals, "a")
+	c.Check(re.Sub[0].Negate, Equals, false)
+	c.Check(re.Sub[0].Pos, Equals, 0)
+
+	star := re.Sub[1]
+	c.Check(star.Op, Equals, OpStar)
+	c.Check(star.Pos, Equals, 12)
+	c.Check(star.Sub[0].Op, Equals, OpClass)
+	c.Check(star.Sub[0].Name, Equals, "b")
+	c.Check(star.Sub[0].Negate, Equals, true)
+	c.Check(star.Sub[0].Pos, Equals, 6)
+
+	// The non-capturing group has no node of its own, and the
+	// alternation has all 3 choices
+	alt := re.Sub[2]
+	c.Check(alt.Op, Equals, OpAlternate)
+	c.Assert(len(alt.Sub), Equals, 3)
+	c.Check(alt.Sub[0].Op, Equals, OpClass)
+	c.Check(alt.Sub[1].Op, Equals, OpAnyObject)
+	c.Check(alt.Sub[1].Pos, Equals, 25)
+	c.Check(alt.Sub[2].Op, Equals, OpEndText)
+}
+
+func (s *MySuite) TestParseTree02(c *C) {
+	re, err := Parse("(?P<x>[:a:]{2,}?) ([:b:]) (?P=x) \\2")
+	c.Assert(err, IsNil)
+	c.Assert(re.Op, Equals, OpConcat)
+	c.Assert(len(re.Sub), Equals, 4)
+
+	x := re.Sub[0]
+	c.Check(x.Op, Equals, OpCapture)
+	c.Check(x.Cap, Equals, 1)
+	c.Check(x.Name, Equals, "x")
+	c.Check(x.Pos, Equals, 0)
+	c.Check(x.Sub[0].Op, Equals, OpRepeat)
+	c.Check(x.Sub[0].Min, Equals, 2)
+	c.Check(x.Sub[0].Max, Equals, -1)
+	c.Check(x.Sub[0].Lazy, Equals, true)
+	c.Check(x.Sub[0].Pos, Equals, 11)
+
+	c.Check(re.Sub[1].Op, Equals, OpCapture)
+	c.Check(re.Sub[1].Cap, Equals, 2)
+	c.Check(re.Sub[1].Name, Equals, "")
+
+	// Named backreferences are resolved to their group number
+	c.Check(re.Sub[2].Op, Equals, OpBackref)
+	c.Check(re.Sub[2].Cap, Equals, 1)
+	c.Check(re.Sub[2].Name, Equals, "x")
+	c.Check(re.Sub[3].Op, Equals, OpBackref)
+	c.Check(re.Sub[3].Cap, Equals, 2)
+	c.Check(re.Sub[3].Pos, Equals, 33)
+}
+
+func (s *MySuite) TestParseTree03(c *C) {
+	re, err := Parse("^ (?<![:a:] [:b:]) (?=[:c:])")
+	c.Assert(err, IsNil)
+	c.Assert(re.Op, Equals, OpConcat)
+	c.Assert(len(re.Sub), Equals, 3)
+
+	c.Check(re.Sub[0].Op, Equals, OpBeginText)
+	behind := re.Sub[1]
+	c.Check(behind.Op, Equals, OpLookbehind)
+	c.Check(behind.Negate, Equals, true)
+	c.Check(behind.Pos, Equals, 2)
+	// The subs are in the order they are written
+	c.Check(behind.Sub[0].Op, Equals, OpConcat)
+	c.Check(behind.Sub[0].Sub[0].Name, Equals, "a")
+	c.Check(behind.Sub[0].Sub[1].Name, Equals, "b")
+
+	ahead := re.Sub[2]
+	c.Check(ahead.Op, Equals, OpLookahead)
+	c.Check(ahead.Negate, Equals, false)
+	c.Check(ahead.Pos, Equals, 19)
+}
+
+func (s *MySuite) TestParseTree04(c *C) {
+	re, err := Parse("[:a: && !(:b: || :c: || :d:)]")
+	c.Assert(err, IsNil)
+	c.Assert(re.Op, Equals, OpClassExpr)
+	c.Check(re.Name, Equals, ":a: && !(:b: || :c: || :d:)")
+
+	expr := re.Class
+	c.Assert(expr.Op, Equals, ClassAnd)
+	c.Assert(len(expr.Sub), Equals, 2)
+	c.Check(expr.Sub[0].Op, Equals, ClassName)
+	c.Check(expr.Sub[0].Name, Equals, "a")
+	// The positions are in the whole regex
+	c.Check(expr.Sub[0].Pos, Equals, 1)
+
+	not := expr.Sub[1]
+	c.Check(not.Op, Equals, ClassNot)
+	c.Check(not.Pos, Equals, 8)
+	or := not.Sub[0]
+	c.Check(or.Op, Equals, ClassOr)
+	c.Assert(len(or.Sub), Equals, 3)
+	c.Check(or.Sub[2].Name, Equals, "d")
+	c.Check(or.Sub[2].Pos, Equals, 24)
+}
+
+func (s *MySuite) TestParseClass01(c *C) {
+	expr, err := ParseClass(":a: || :b: && :c:")
+	c.Assert(err, IsNil)
+
+	// && is evaluated before ||
+	c.Assert(expr.Op, Equals, ClassOr)
+	c.Check(expr.Sub[0].Name, Equals, "a")
+	c.Check(expr.Sub[1].Op, Equals, ClassAnd)
+	c.Check(expr.Sub[1].Pos, Equals, 7)
+
+	_, err = ParseClass(":a: && :b: &&")
+	c.Check(err, NotNil)
+
+	_, err = ParseClass("()")
+	c.Check(err, NotNil)
+}
+
+// Every error is returned, along with as much of the tree as was parsed
+func (s *MySuite) TestParseAll01(c *C) {
+	re, errs := ParseAll("[:a:] [:b] (?P<x>.) (?P<x>.)")
+	c.Assert(len(errs), Equals, 2)
+	c.Check(errs[0].Code, Equals, ErrInvalidClass)
+	c.Check(errs[1].Code, Equals, ErrInvalidGroup)
+	c.Check(errs[1].ByteOffset, Equals, 20)
+
+	c.Assert(re, NotNil)
+	c.Assert(len(re.Sub), Equals, 4)
+	c.Check(re.Sub[1].Op, Equals, OpAnyObject)
+
+	re, errs = ParseAll("[:a:] [:b:] (")
+	c.Assert(len(errs), Equals, 1)
+	c.Check(errs[0].Code, Equals, ErrUnexpectedEOF)
+	c.Assert(re, NotNil)
+	c.Check(re.Op, Equals, OpConcat)
+	c.Check(len(re.Sub), Equals, 2)
+
+	_, err := Parse("[:a:] [:b] (?P<x>.) (?P<x>.)")
+	_, ok := err.(Errors)
+	c.Check(ok, Equals, true)
+}
+
+// A '|' must have a choice on each side of it
+func (s *MySuite) TestParsePipe01(c *C) {
+	for _, t := range []struct {
+		pattern string
+		offset  int
+		msg     string
+	}{
+		{"[:a:] |", 6, "'\\|' at pos 7 has nothing after it"},
+		{"[:a:] | # a comment", 6, "'\\|' at pos 7 has nothing after it"},
+		{"| [:a:]", 0, "'\\|' at pos 1 is not allowed"},
+		{"([:a:] |) [:b:]", 7, "'\\|' at pos 8 has nothing after it"},
+		{"[:a:] | (?:[:b:] | )", 17, "'\\|' at pos 18 has nothing after it"},
+	} {
+		re, err := Parse(t.pattern)
+		c.Check(re, IsNil, Commentf(t.pattern))
+		c.Assert(err, FitsTypeOf, &Error{}, Commentf(t.pattern))
+		serr := err.(*Error)
+		c.Check(serr.Code, Equals, ErrMissingOperand, Commentf(t.pattern))
+		c.Check(serr.ByteOffset, Equals, t.offset, Commentf(t.pattern))
+		c.Check(serr, ErrorMatches, t.msg, Commentf(t.pattern))
+	}
+
+	re, err := Parse("[:a:] | ([:b:] | [:c:])")
+	c.Assert(err, IsNil)
+	c.Check(re.String(), Equals, "[:a:] | ([:b:] | [:c:])")
+}
+
+// A class name can end with an argument in parens
+func (s *MySuite) TestParseArg01(c *C) {
+	re, err := Parse("[:longer(5):] [:a: && !:between(2, 3):]")
+	c.Assert(err, IsNil)
+	c.Check(re.Sub[0].Name, Equals, "longer(5)")
+	c.Check(re.Sub[1].Class.Sub[1].Sub[0].Name, Equals, "between(2, 3)")
+	c.Check(re.String(), Equals, "[:longer(5):] [:a: && !:between(2, 3):]")
+
+	// The value of a field can have any parens
+	re, err = Parse("[:word=(:] [:a: || :word=f(x)):] [:p.word=):]")
+	c.Assert(err, IsNil)
+	c.Check(re.Sub[0].Name, Equals, "word=(")
+	c.Check(re.Sub[1].Class.Sub[1].Name, Equals, "word=f(x))")
+	c.Check(re.Sub[2].Name, Equals, "p.word=)")
+
+	factory, arg, argOffset, ok := SplitArg("between(2, 3)")
+	c.Check(ok, Equals, true)
+	c.Check(factory, Equals, "between")
+	c.Check(arg, Equals, "2, 3")
+	c.Check(argOffset, Equals, 8)
+	_, arg, _, ok = SplitArg("longer()")
+	c.Check(ok, Equals, true)
+	c.Check(arg, Equals, "")
+	_, _, _, ok = SplitArg("longer")
+	c.Check(ok, Equals, false)
+
+	for _, t := range []struct {
+		pattern string
+		code    ErrorCode
+		offset  int
+		msg     string
+	}{
+		{"[:a:] [:longer(5:]", ErrUnbalancedParen, 14,
+			"The class name at pos 8 has '\\(' without a '\\)' at pos 14"},
+		{"[:longer5):]", ErrUnbalancedParen, 9,
+			"The class name at pos 2 has '\\)' without a '\\(' at pos 9"},
+		{"[:(5):]", ErrInvalidName, 2,
+			"The class name at pos 2 has '\\(' without a class name before it at pos 2"},
+		{"[:a((5)):]", ErrInvalidName, 4,
+			"The class name at pos 2 has '\\(' inside of a class argument at pos 4"},
+		{"[:a(5)b:]", ErrInvalidName, 6,
+			"The class name at pos 2 has text after the '\\)' of a class argument at pos 6"},
+		{"[:x: && :a(5:]", ErrUnbalancedParen, 10,
+			"Parsing class string at pos 1: The class name at pos 8 has '\\(' without a '\\)' at pos 9"},
+	} {
+		_, errs := ParseAll(t.pattern)
+		c.Assert(errs, HasLen, 1, Commentf(t.pattern))
+		c.Check(errs[0].Code, Equals, t.code, Commentf(t.pattern))
+		c.Check(errs[0].ByteOffset, Equals, t.offset, Commentf(t.pattern))
+		c.Check(errs[0], ErrorMatches, t.msg, Commentf(t.pattern))
+	}
+}